@@ -1 +0,0 @@
-psql -d "$POSTGRES_DB" -U "$POSTGRES_USER" -f sql/users.sql
\ No newline at end of file
@@ -1,65 +0,0 @@
---
--- Name: user_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
---
-
-CREATE SEQUENCE public.user_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
-
-ALTER TABLE public.user_id_seq OWNER TO postgres;
-
-SET default_tablespace = '';
-SET default_table_access_method = heap;
-
---
--- Name: users; Type: TABLE; Schema: public; Owner: postgres
---
-
-CREATE TABLE public.users (
-    id integer DEFAULT nextval('public.user_id_seq'::regclass) NOT NULL,
-    email character varying(40) NOT NULL,
-    first_name character varying(255),
-    last_name character varying(255),
-    password character varying(60) NOT NULL,
-    active bool DEFAULT FALSE,
-    created_at timestamp without time zone,
-    updated_at timestamp without time zone
-);
-
-ALTER TABLE public.users OWNER TO postgres;
-
---
--- Name: user_id_seq; Type: SEQUENCE SET; Schema: public; Owner: postgres
---
-
-SELECT pg_catalog.setval('public.user_id_seq', 1, true);
-
---
--- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
---
-
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_pkey PRIMARY KEY (id);
-
-INSERT INTO public.users(
-    "email",
-    "first_name",
-    "last_name",
-    "password",
-    "active",
-    "created_at",
-    "updated_at"
-)
-VALUES
-(
-    E'admin@example.com',
-    E'Admin',
-    E'User',
-    E'$2a$12$txp8To/XkvyEfdM4WLA3Ze/wM5rOH9SPQGgmfsH9kDQ2ZqWDKaWCa',
-    E'TRUE',
-    E'2023-03-10 00:00:00',
-    E'2023-03-10 00:00:00'
-)
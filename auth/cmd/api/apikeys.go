@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"auth/data"
+)
+
+// apiKeyScheme is the Authorization header scheme of API keys.
+const apiKeyScheme = "ApiKey"
+
+var errAPIKeyNotFound = errors.New("API key not found")
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is a duration such as "720h". Keys without it never expire.
+	ExpiresIn string `json:"expires_in"`
+}
+
+// ListAPIKeys returns API keys of the authenticated user, without the keys themselves.
+func (s *Service) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	keys, err := s.Models.APIKey.GetAllForUser(user.ID)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("Found %d API keys", len(keys)),
+		Data:    keys,
+	})
+}
+
+// CreateAPIKey issues new API key of the authenticated user. The key can
+// only have scopes among the user's permissions, and it is returned only
+// in this response.
+func (s *Service) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	var reqPayload createAPIKeyRequest
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	permissions, err := s.Models.Role.GetPermissionsForUser(user.ID)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	v := newValidator()
+	v.Check(strings.TrimSpace(reqPayload.Name) != "", "name", "must be provided")
+	v.Check(len(reqPayload.Name) <= 255, "name", "must not be more than 255 bytes long")
+	v.Check(len(reqPayload.Scopes) > 0, "scopes", "must be provided")
+	for _, scope := range reqPayload.Scopes {
+		if !contains(permissions, scope) {
+			v.AddError("scopes", fmt.Sprintf("must be among your permissions: %s", strings.Join(permissions, ", ")))
+			break
+		}
+	}
+
+	var ttl time.Duration
+	if reqPayload.ExpiresIn != "" {
+		ttl, err = time.ParseDuration(reqPayload.ExpiresIn)
+		v.Check(err == nil && ttl > 0, "expires_in", "must be a positive duration, such as 720h")
+	}
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	key, err := data.GenerateAPIKey(user.ID, reqPayload.Name, unique(reqPayload.Scopes), ttl)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	id, err := s.models(r).APIKey.Insert(*key)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	key.ID = id
+	key.CreatedAt = time.Now()
+
+	_ = s.writeJSON(w, http.StatusCreated, jsonResponse{
+		Message: fmt.Sprintf("Created API key %s, copy it now, it won't be shown again", key.Name),
+		Data:    key,
+	})
+}
+
+// RevokeAPIKey deletes API key of the authenticated user by id.
+func (s *Service) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		_ = s.errorJSON(w, errors.New("invalid id parameter"))
+		return
+	}
+
+	if err := s.models(r).APIKey.Delete(id, user.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = s.errorJSON(w, errAPIKeyNotFound, http.StatusNotFound)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("Revoked API key %d", id),
+	})
+}
+
+// validateAPIKey checks the API key and returns identity of its user. The
+// permissions are the key's scopes the user still has, so a key never
+// grants more than its user.
+func (s *Service) validateAPIKey(plainText string) (*validateResponse, error) {
+	key, err := s.Models.APIKey.GetByPlainText(plainText)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	user, err := s.Models.User.GetOne(key.UserID)
+	if err != nil || !user.Active {
+		return nil, errInvalidToken
+	}
+
+	roles, err := s.Models.Role.GetForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	userPermissions, err := s.Models.Role.GetPermissionsForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := []string{}
+	for _, scope := range key.Scopes {
+		if contains(userPermissions, scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+
+	if err := s.Models.APIKey.Touch(key.ID); err != nil {
+		log.Printf("Error on touch API key %d: %v\n", key.ID, err)
+	}
+
+	return &validateResponse{
+		UserID:      user.ID,
+		Email:       user.Email,
+		Roles:       roles,
+		Permissions: permissions,
+		APIKeyID:    key.ID,
+	}, nil
+}
+
+// apiKeyFromHeader gets API key from the Authorization header of the request,
+// if it uses the ApiKey scheme.
+func apiKeyFromHeader(r *http.Request) (string, bool) {
+	headerParts := strings.Fields(r.Header.Get("Authorization"))
+	if len(headerParts) != 2 || !strings.EqualFold(headerParts[0], apiKeyScheme) {
+		return "", false
+	}
+
+	return headerParts[1], true
+}
+
+// contains returns true if the list has the value.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// unique returns values of the list without repeats, in the original order.
+func unique(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"auth/data"
+	"auth/event"
+)
+
+const (
+	// auditRelayInterval is how often events left in the outbox are
+	// published again.
+	auditRelayInterval = time.Second * 30
+	// auditRelayDelay is how old events have to be to be published by the
+	// relay, so it doesn't race the requests publishing them.
+	auditRelayDelay = time.Minute
+	auditRelayBatch = 100
+)
+
+var errNotConnected = errors.New("not connected to RabbitMQ")
+
+type auditPage struct {
+	Events   []*data.AuditEvent `json:"events"`
+	Metadata pageMetadata       `json:"metadata"`
+}
+
+// auditPublisher sends audit events to the logs_topic exchange, so the
+// listener passes them to the logger. It doesn't wait for RabbitMQ while
+// disconnected, the events stay in the outbox until relayAudit reconnects
+// and publishes them.
+type auditPublisher struct {
+	url string
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	emitter event.Emitter
+}
+
+// newAuditPublisher creates auditPublisher of RabbitMQ at url. conn may be
+// nil if RabbitMQ isn't reachable yet.
+func newAuditPublisher(url string, conn *amqp.Connection) *auditPublisher {
+	p := &auditPublisher{url: url}
+	if conn != nil {
+		if err := p.use(conn); err != nil {
+			log.Printf("Setting up the audit emitter failed: %v\n", err)
+		}
+	}
+	return p
+}
+
+// use publishes through conn from now on.
+func (p *auditPublisher) use(conn *amqp.Connection) error {
+	emitter, err := event.NewEventEmitter(conn)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.conn, p.emitter = conn, emitter
+	return nil
+}
+
+// connected returns true if the connection to RabbitMQ is open.
+func (p *auditPublisher) connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.conn != nil && !p.conn.IsClosed()
+}
+
+// reconnect connects to RabbitMQ again if the connection was lost.
+func (p *auditPublisher) reconnect() error {
+	if p.connected() {
+		return nil
+	}
+
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return err
+	}
+	if err := p.use(conn); err != nil {
+		return err
+	}
+
+	log.Println("Reconnected to RabbitMQ!")
+	return nil
+}
+
+// Close closes the connection to RabbitMQ.
+func (p *auditPublisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+}
+
+// PublishAudit publishes the audit event as a log entry named "audit".
+func (p *auditPublisher) PublishAudit(e data.AuditEvent) error {
+	if !p.connected() {
+		return errNotConnected
+	}
+
+	entry, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event.Payload{
+		Name: "audit",
+		Data: string(entry),
+	})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	emitter := p.emitter
+	p.mu.Unlock()
+
+	return emitter.Push(payload, "log.INFO")
+}
+
+// relayAudit publishes the events left in the outbox, reconnecting to
+// RabbitMQ if needed, every auditRelayInterval. It never returns.
+func (s *Service) relayAudit(publisher *auditPublisher) {
+	ticker := time.NewTicker(auditRelayInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := publisher.reconnect(); err != nil {
+			log.Printf("Reconnecting to RabbitMQ failed: %v\n", err)
+			continue
+		}
+
+		events, err := s.Models.Audit.GetUnpublished(time.Now().Add(-auditRelayDelay), auditRelayBatch)
+		if err != nil {
+			log.Printf("Getting unpublished audit events failed: %v\n", err)
+			continue
+		}
+
+		for _, e := range events {
+			if err := publisher.PublishAudit(*e); err != nil {
+				log.Printf("Publishing audit event %d failed: %v\n", e.ID, err)
+				break
+			}
+			if err := s.Models.Audit.MarkPublished(e.ID); err != nil {
+				log.Printf("Marking audit event %d published failed: %v\n", e.ID, err)
+			}
+		}
+	}
+}
+
+// GetUserAudit returns one page of the audit trail of the user whose id is
+// in the URL, newest changes first. The trail of deleted users is kept,
+// so the user doesn't have to exist.
+func (s *Service) GetUserAudit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		_ = s.errorJSON(w, errors.New("invalid id parameter"))
+		return
+	}
+
+	v := newValidator()
+
+	page, pageSize := readPageQuery(r, v)
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	events, total, err := s.Models.Audit.GetPageForUser(id, pageSize, (page-1)*pageSize)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("Found %d audit events", total),
+		Data: auditPage{
+			Events:   events,
+			Metadata: newPageMetadata(page, pageSize, total),
+		},
+	})
+}
+
+// models returns Models which record the caller of the request as the one
+// making the changes. The caller is the user authenticated by requireUser
+// or requireCaller, if any.
+func (s *Service) models(r *http.Request) data.Models {
+	actor := data.Actor{IP: s.clientIP(r)}
+
+	if user := userFromContext(r.Context()); user != nil {
+		actor.UserID = user.ID
+	}
+
+	return s.Models.As(actor)
+}
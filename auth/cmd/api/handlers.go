@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+
+	"auth/data"
 )
 
 type authRequest struct {
@@ -14,6 +17,25 @@ type authRequest struct {
 	Password string `json:"password"`
 }
 
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	All          bool   `json:"all,omitempty"`
+}
+
+type authResponse struct {
+	User *data.User `json:"user"`
+	tokenPair
+}
+
+type validateResponse struct {
+	UserID      int      `json:"user_id"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	// APIKeyID is set when the caller authenticated with an API key.
+	APIKeyID int `json:"api_key_id,omitempty"`
+}
+
 func (s *Service) Authenticate(w http.ResponseWriter, r *http.Request) {
 	reqPayload := authRequest{}
 
@@ -24,10 +46,22 @@ func (s *Service) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// refuse locked out and throttled logins before checking the password
+	ip := s.clientIP(r)
+	if retryAfter, err := s.checkLoginAllowed(reqPayload.Email, ip); err != nil {
+		if retryAfter > 0 {
+			_ = s.tooManyRequestsJSON(w, err, retryAfter)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// validate the user exists in the database
 	user, err := s.Models.User.GetByEmail(reqPayload.Email)
 	if err != nil {
 		log.Println(err)
+		s.recordLoginFailure(reqPayload.Email, ip)
 		_ = s.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
@@ -36,10 +70,38 @@ func (s *Service) Authenticate(w http.ResponseWriter, r *http.Request) {
 	passwordIsValid, err := user.PasswordMatches(reqPayload.Password)
 	if err != nil || !passwordIsValid {
 		log.Println(err)
+		s.recordLoginFailure(reqPayload.Email, ip)
 		_ = s.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
 
+	// deactivated and not yet verified users can't log in
+	if !user.Active {
+		_ = s.errorJSON(w, errInactiveAccount, http.StatusForbidden)
+		return
+	}
+
+	s.rehashPassword(user, reqPayload.Password)
+
+	// users with two-factor authentication get a challenge instead of
+	// tokens, which is completed at /authenticate/2fa
+	totp, err := s.Models.TwoFactor.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err == nil && totp.Confirmed {
+		s.issueChallenge(w, user)
+		return
+	}
+
+	s.completeLogin(w, user, ip)
+}
+
+// completeLogin issues the tokens to the user who passed all factors.
+func (s *Service) completeLogin(w http.ResponseWriter, user *data.User, ip string) {
+	s.resetLoginFailures(user.Email, ip)
+
 	// log authentication
 	if err := s.logRequest(
 		"authentication",
@@ -50,9 +112,19 @@ func (s *Service) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tokens, err := s.issueTokens(user)
+	if err != nil {
+		log.Printf("Error on issue tokens: %v\n", err)
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	resPayload := jsonResponse{
 		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    user,
+		Data: authResponse{
+			User:      user,
+			tokenPair: *tokens,
+		},
 	}
 	if err := s.writeJSON(w, http.StatusAccepted, resPayload); err != nil {
 		log.Println(err)
@@ -61,6 +133,162 @@ func (s *Service) Authenticate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// rehashPassword upgrades the password hash of the user who has just logged
+// in with the password, if it was made with an outdated algorithm or
+// parameters, while the plain text password is at hand.
+func (s *Service) rehashPassword(user *data.User, password string) {
+	if !s.Hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hash, err := s.Hasher.Hash(password)
+	if err != nil {
+		log.Printf("Error on rehash password of user %d: %v\n", user.ID, err)
+		return
+	}
+
+	if err := s.Models.User.Rehash(user.ID, hash); err != nil {
+		log.Printf("Error on rehash password of user %d: %v\n", user.ID, err)
+	}
+}
+
+// Refresh exchanges a valid refresh token for a new pair of tokens.
+// The refresh token is consumed in the same statement which reads it, so
+// every refresh token works only once, even for concurrent requests.
+func (s *Service) Refresh(w http.ResponseWriter, r *http.Request) {
+	var reqPayload refreshRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	token, err := s.Models.Token.Consume(reqPayload.RefreshToken)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	user, err := s.Models.User.GetOne(token.UserID)
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	if !user.Active {
+		_ = s.errorJSON(w, errInactiveAccount, http.StatusForbidden)
+		return
+	}
+
+	tokens, err := s.issueTokens(user)
+	if err != nil {
+		log.Printf("Error on issue tokens: %v\n", err)
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: fmt.Sprintf("Refreshed tokens for user %s", user.Email),
+		Data: authResponse{
+			User:      user,
+			tokenPair: *tokens,
+		},
+	})
+}
+
+// Logout revokes the given refresh token, or every refresh token
+// of its owner when "all" is set.
+func (s *Service) Logout(w http.ResponseWriter, r *http.Request) {
+	var reqPayload refreshRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	token, err := s.Models.Token.GetByPlainText(reqPayload.RefreshToken)
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	if reqPayload.All {
+		err = s.Models.Token.DeleteAllForUser(token.UserID)
+	} else {
+		err = s.Models.Token.DeleteByPlainText(reqPayload.RefreshToken)
+	}
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: "Logged out",
+	})
+}
+
+// Validate checks the access token or the API key from the Authorization
+// header and returns identity of its owner. It is meant to be called by
+// other services. Tokens of users deleted or deactivated since they were
+// issued are rejected, like API keys of such users are.
+func (s *Service) Validate(w http.ResponseWriter, r *http.Request) {
+	if key, ok := apiKeyFromHeader(r); ok {
+		id, err := s.validateAPIKey(key)
+		if err != nil {
+			if errors.Is(err, errInvalidToken) {
+				_ = s.errorJSON(w, err, http.StatusUnauthorized)
+			} else {
+				_ = s.errorJSON(w, err, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+			Message: "API key is valid",
+			Data:    id,
+		})
+		return
+	}
+
+	tokenString, err := bearerToken(r)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.parseAccessToken(tokenString)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.Models.User.GetOne(userID)
+	if err != nil || !user.Active {
+		_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: "Token is valid",
+		Data: validateResponse{
+			UserID:      userID,
+			Email:       claims.Email,
+			Roles:       claims.Roles,
+			Permissions: claims.Permissions,
+		},
+	})
+}
+
 func (s *Service) logRequest(name, data string) error {
 	var entry struct {
 		Name string `json:"name"`
@@ -86,12 +314,54 @@ func (s *Service) logRequest(name, data string) error {
 		return err
 	}
 
-	client := &http.Client{}
-	_, err = client.Do(request)
+	response, err := s.Client.Do(request)
 	if err != nil {
 		log.Printf("Error on logger request: %v\n", err)
 		return err
 	}
+	response.Body.Close()
+
+	return nil
+}
+
+// mailMessage is the message sent through the mail service. Template names
+// one of the mail service's templates, which gets Data besides the message.
+type mailMessage struct {
+	To       string         `json:"to"`
+	Subject  string         `json:"subject"`
+	Message  string         `json:"message"`
+	Template string         `json:"template,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+func (s *Service) sendMail(msg mailMessage) error {
+	jsonData, err := json.MarshalIndent(msg, "", "\t")
+	if err != nil {
+		return err
+	}
+	mailServiceURL := "http://mail/send"
+
+	request, err := http.NewRequest(
+		http.MethodPost,
+		mailServiceURL,
+		bytes.NewBuffer(jsonData),
+	)
+	if err != nil {
+		log.Printf("Error on make mail request: %v\n", err)
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		log.Printf("Error on mail request: %v\n", err)
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("mail service responded with status %d", response.StatusCode)
+	}
 
 	return nil
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/data"
+)
+
+const testPassword = "correct horse battery"
+
+// roundTripFunc stubs the calls to the other services.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// newTestService creates Service with in-memory repositories. The logger
+// and mail services it calls answer 202 to everything.
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusAccepted,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader("{}")),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	hasher := &data.BcryptHasher{Cost: 4}
+
+	return &Service{
+		Models:          data.NewInMemory(hasher),
+		Hasher:          hasher,
+		Client:          client,
+		JWTSecret:       []byte("test secret"),
+		AccessTokenTTL:  accessTokenTTL,
+		RefreshTokenTTL: refreshTokenTTL,
+		Throttle: loginThrottle{
+			MaxFailures:      loginMaxFailures,
+			MaxFailuresPerIP: loginMaxFailuresPerIP,
+			BaseDelay:        loginBaseDelay,
+			MaxDelay:         loginMaxDelay,
+			LockoutDuration:  loginLockoutDuration,
+		},
+		MFAChallengeTTL: mfaChallengeTTL,
+	}
+}
+
+// addTestUser inserts the user with testPassword and the user role.
+func addTestUser(t *testing.T, s *Service, email string, active bool) *data.User {
+	t.Helper()
+
+	id, err := s.Models.User.Insert(data.User{Email: email, Password: testPassword, Active: active})
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	if err := s.Models.Role.SetForUser(id, []string{data.RoleUser}); err != nil {
+		t.Fatalf("setting roles: %v", err)
+	}
+
+	user, err := s.Models.User.GetOne(id)
+	if err != nil {
+		t.Fatalf("getting user: %v", err)
+	}
+	return user
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, s *Service)
+		email      string
+		password   string
+		wantStatus int
+		wantTokens bool
+	}{
+		{
+			name:       "success",
+			setup:      func(t *testing.T, s *Service) { addTestUser(t, s, "ann@example.com", true) },
+			email:      "ann@example.com",
+			password:   testPassword,
+			wantStatus: http.StatusAccepted,
+			wantTokens: true,
+		},
+		{
+			name:       "wrong password",
+			setup:      func(t *testing.T, s *Service) { addTestUser(t, s, "ann@example.com", true) },
+			email:      "ann@example.com",
+			password:   "wrong password",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown email",
+			setup:      func(t *testing.T, s *Service) {},
+			email:      "nobody@example.com",
+			password:   testPassword,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "inactive user",
+			setup:      func(t *testing.T, s *Service) { addTestUser(t, s, "ann@example.com", false) },
+			email:      "ann@example.com",
+			password:   testPassword,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "locked out",
+			setup: func(t *testing.T, s *Service) {
+				addTestUser(t, s, "ann@example.com", true)
+				if _, err := s.Models.LoginAttempt.RecordFailure(scopeEmail, "ann@example.com"); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Models.LoginAttempt.Lock(scopeEmail, "ann@example.com", time.Now().Add(time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			email:      "ann@example.com",
+			password:   testPassword,
+			wantStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			tt.setup(t, s)
+
+			body, _ := json.Marshal(authRequest{Email: tt.email, Password: tt.password})
+			w := httptest.NewRecorder()
+			s.Authenticate(w, httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body)))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			var response struct {
+				Error bool         `json:"error"`
+				Data  authResponse `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if response.Error == tt.wantTokens {
+				t.Errorf("error = %v, want %v", response.Error, !tt.wantTokens)
+			}
+			if got := response.Data.AccessToken != "" && response.Data.RefreshToken != ""; got != tt.wantTokens {
+				t.Errorf("got tokens = %v, want %v", got, tt.wantTokens)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After header is missing")
+			}
+		})
+	}
+}
+
+func TestAuthenticateLocksOutAfterMaxFailures(t *testing.T) {
+	s := newTestService(t)
+	s.Throttle.BaseDelay = 0
+	s.Throttle.MaxDelay = 0
+	addTestUser(t, s, "ann@example.com", true)
+
+	login := func(password string) int {
+		body, _ := json.Marshal(authRequest{Email: "ann@example.com", Password: password})
+		w := httptest.NewRecorder()
+		s.Authenticate(w, httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	for i := 0; i < s.Throttle.MaxFailures; i++ {
+		if status := login("wrong password"); status != http.StatusBadRequest {
+			t.Fatalf("failure %d: status = %d, want %d", i+1, status, http.StatusBadRequest)
+		}
+	}
+
+	if status := login(testPassword); status != http.StatusTooManyRequests {
+		t.Fatalf("status after lockout = %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
+
+func TestAuthenticateLocksOutConcurrentFailures(t *testing.T) {
+	s := newTestService(t)
+	s.Throttle.BaseDelay = 0
+	s.Throttle.MaxDelay = 0
+	addTestUser(t, s, "ann@example.com", true)
+
+	body, _ := json.Marshal(authRequest{Email: "ann@example.com", Password: "wrong password"})
+
+	attempts := 4 * s.Throttle.MaxFailures
+	statuses := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			s.Authenticate(w, httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body)))
+			statuses <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	checked := 0
+	for status := range statuses {
+		if status == http.StatusBadRequest {
+			checked++
+		}
+	}
+	if checked > s.Throttle.MaxFailures {
+		t.Errorf("%d of %d concurrent logins checked the password, want at most %d", checked, attempts, s.Throttle.MaxFailures)
+	}
+}
+
+func TestAuthenticateRehashesOnlyActiveLogins(t *testing.T) {
+	for _, active := range []bool{true, false} {
+		s := newTestService(t)
+		user := addTestUser(t, s, "ann@example.com", active)
+
+		// the hash of the user is now outdated
+		s.Hasher = &data.BcryptHasher{Cost: 5}
+
+		body, _ := json.Marshal(authRequest{Email: user.Email, Password: testPassword})
+		w := httptest.NewRecorder()
+		s.Authenticate(w, httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body)))
+
+		after, err := s.Models.User.GetOne(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rehashed := after.Password != user.Password; rehashed != active {
+			t.Errorf("active %v: rehashed = %v, want %v", active, rehashed, active)
+		}
+	}
+}
+
+func TestValidateRejectsTokensOfInactiveUsers(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(t *testing.T, s *Service, user *data.User)
+		wantStatus int
+	}{
+		{
+			name:       "active user",
+			change:     func(t *testing.T, s *Service, user *data.User) {},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "deactivated user",
+			change: func(t *testing.T, s *Service, user *data.User) {
+				user.Active = false
+				if err := s.Models.User.Update(*user); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "deleted user",
+			change: func(t *testing.T, s *Service, user *data.User) {
+				if err := s.Models.User.Delete(user.ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			user := addTestUser(t, s, "ann@example.com", true)
+
+			tokens, err := s.issueTokens(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, s, user)
+
+			r := httptest.NewRequest(http.MethodPost, "/validate", nil)
+			r.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+			w := httptest.NewRecorder()
+			s.Validate(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
+	amqp "github.com/rabbitmq/amqp091-go"
 
 	"auth/data"
 )
@@ -18,15 +22,66 @@ const (
 )
 
 var (
-	dbConnAttempts = 10
+	dbConnAttempts     = 10
+	rabbitConnAttempts = 10
+
+	accessTokenTTL  = time.Minute * 15
+	refreshTokenTTL = time.Hour * 24 * 7
+
+	loginMaxFailures      = 5
+	loginMaxFailuresPerIP = 20
+	loginBaseDelay        = time.Second
+	loginMaxDelay         = time.Second * 30
+	loginLockoutDuration  = time.Minute * 15
+
+	verificationTTL  = time.Hour * 24
+	passwordResetTTL = time.Hour
+
+	passwordHashAlgorithm = data.AlgorithmArgon2id
+	bcryptCost            = 12
+	argon2Memory          = 64 * 1024
+	argon2Iterations      = 3
+	argon2Parallelism     = 2
+	mfaChallengeTTL       = time.Minute * 5
+	totpIssuer            = "microservices-example"
+	oidcIssuer            = "http://localhost:8081"
+	oidcCodeTTL           = time.Minute * 5
+	oidcIDTokenTTL        = time.Hour
 )
 
 type Service struct {
 	DB     *sqlx.DB
 	Models data.Models
+	Hasher data.PasswordHasher
+	// Client calls the logger and mail services.
+	Client *http.Client
+
+	JWTSecret       []byte
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	Throttle loginThrottle
+	// TrustedProxies are the networks of the proxies, such as the broker,
+	// which pass the address of the client in X-Real-IP.
+	TrustedProxies []*net.IPNet
+
+	VerificationURL string
+	VerificationTTL time.Duration
+
+	PasswordResetURL string
+	PasswordResetTTL time.Duration
+
+	MFAChallengeTTL time.Duration
+	TOTPIssuer      string
+
+	OIDC *oidcProvider
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	log.Println("Starting authentication service")
 
 	// connect to DB
@@ -35,12 +90,100 @@ func main() {
 		log.Panic("Can't connect to Postgres!")
 	}
 
+	if err := migrateUp(dbConn); err != nil {
+		log.Panic(err)
+	}
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Panic("JWT_SECRET is not set!")
+	}
+
+	algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM")
+	if algorithm == "" {
+		algorithm = passwordHashAlgorithm
+	}
+
+	hasher, err := data.NewPasswordHasher(
+		algorithm,
+		intFromEnv("BCRYPT_COST", bcryptCost),
+		data.Argon2idHasher{
+			Memory:      uint32(intFromEnv("ARGON2_MEMORY", argon2Memory)),
+			Iterations:  uint32(intFromEnv("ARGON2_ITERATIONS", argon2Iterations)),
+			Parallelism: uint8(intFromEnv("ARGON2_PARALLELISM", argon2Parallelism)),
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+	)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	encryptionKey, err := base64.StdEncoding.DecodeString(os.Getenv("TOTP_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Panic("TOTP_ENCRYPTION_KEY is not valid base64!")
+	}
+
+	box, err := data.NewSecretBox(encryptionKey)
+	if err != nil {
+		log.Panicf("TOTP_ENCRYPTION_KEY: %v", err)
+	}
+
+	// audit events are passed to the logger through RabbitMQ. Without it
+	// they are kept in the outbox, and published once it's back.
+	rabbitConn := connectToRabbit()
+	if rabbitConn == nil {
+		log.Println("Can't connect to RabbitMQ, audit events will be published later")
+	}
+	publisher := newAuditPublisher(os.Getenv("RMQ_URL"), rabbitConn)
+	defer publisher.Close()
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = totpIssuer
+	}
+
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Panicf("TRUSTED_PROXIES: %v", err)
+	}
+
+	oidc, err := setupOIDC()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	// set up app
 	service := Service{
-		DB:     dbConn,
-		Models: data.New(dbConn),
+		DB:              dbConn,
+		Models:          data.New(dbConn, hasher, box, publisher),
+		Hasher:          hasher,
+		Client:          &http.Client{},
+		JWTSecret:       []byte(jwtSecret),
+		AccessTokenTTL:  durationFromEnv("ACCESS_TOKEN_TTL", accessTokenTTL),
+		RefreshTokenTTL: durationFromEnv("REFRESH_TOKEN_TTL", refreshTokenTTL),
+		Throttle: loginThrottle{
+			MaxFailures:      intFromEnv("LOGIN_MAX_FAILURES", loginMaxFailures),
+			MaxFailuresPerIP: intFromEnv("LOGIN_MAX_FAILURES_PER_IP", loginMaxFailuresPerIP),
+			BaseDelay:        durationFromEnv("LOGIN_BASE_DELAY", loginBaseDelay),
+			MaxDelay:         durationFromEnv("LOGIN_MAX_DELAY", loginMaxDelay),
+			LockoutDuration:  durationFromEnv("LOGIN_LOCKOUT_DURATION", loginLockoutDuration),
+		},
+		TrustedProxies:  trustedProxies,
+		VerificationURL: os.Getenv("VERIFICATION_URL"),
+		VerificationTTL: durationFromEnv("VERIFICATION_TTL", verificationTTL),
+
+		PasswordResetURL: os.Getenv("PASSWORD_RESET_URL"),
+		PasswordResetTTL: durationFromEnv("PASSWORD_RESET_TTL", passwordResetTTL),
+
+		MFAChallengeTTL: durationFromEnv("MFA_CHALLENGE_TTL", mfaChallengeTTL),
+		TOTPIssuer:      issuer,
+
+		OIDC: oidc,
 	}
 
+	go service.relayAudit(publisher)
+
 	// create server
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", webPort),
@@ -86,3 +229,87 @@ func connectToDB() *sqlx.DB {
 		time.Sleep(time.Second * 2)
 	}
 }
+
+// setupOIDC creates the OpenID Connect provider configured by the environment.
+// Without OIDC_SIGNING_KEY_FILE a new signing key is generated on every start,
+// which invalidates ID tokens issued before and doesn't work with replicas.
+func setupOIDC() (*oidcProvider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		issuer = oidcIssuer
+	}
+
+	var keyPEM []byte
+	if keyFile := os.Getenv("OIDC_SIGNING_KEY_FILE"); keyFile != "" {
+		var err error
+		if keyPEM, err = os.ReadFile(keyFile); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Println("OIDC_SIGNING_KEY_FILE is not set, generating a temporary signing key")
+	}
+
+	return newOIDCProvider(
+		issuer,
+		keyPEM,
+		os.Getenv("OIDC_CLIENTS"),
+		durationFromEnv("OIDC_CODE_TTL", oidcCodeTTL),
+		durationFromEnv("OIDC_ID_TOKEN_TTL", oidcIDTokenTTL),
+	)
+}
+
+// connectToRabbit
+func connectToRabbit() *amqp.Connection {
+	attempts := rabbitConnAttempts
+	for {
+		conn, err := amqp.Dial(os.Getenv("RMQ_URL"))
+		if err != nil {
+			log.Println("RabbitMQ not yet ready...")
+			attempts--
+		} else {
+			log.Println("Connected to RabbitMQ!")
+			return conn
+		}
+
+		if attempts == 0 {
+			return nil
+		}
+
+		log.Println("Backing off for two seconds...")
+		time.Sleep(time.Second * 2)
+	}
+}
+
+// durationFromEnv reads duration from environment variable key
+// and falls back to def when it is not set or malformed.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Malformed %s %q, using default %s\n", key, value, def)
+		return def
+	}
+
+	return d
+}
+
+// intFromEnv reads integer from environment variable key
+// and falls back to def when it is not set or malformed.
+func intFromEnv(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Malformed %s %q, using default %d\n", key, value, def)
+		return def
+	}
+
+	return i
+}
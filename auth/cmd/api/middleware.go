@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"auth/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey        contextKey = "user"
+	permissionsContextKey contextKey = "permissions"
+)
+
+var errForbidden = errors.New("forbidden")
+
+// requireUser rejects requests without a valid access token and puts the
+// user owning the token into the request context.
+func (s *Service) requireUser(next http.Handler) http.Handler {
+	return s.authenticate(false, next)
+}
+
+// requireCaller is requireUser which also accepts API keys. It guards the
+// endpoints the broker calls on behalf of its callers, which pass either.
+func (s *Service) requireCaller(next http.Handler) http.Handler {
+	return s.authenticate(true, next)
+}
+
+// authenticate puts the user authenticated by the Authorization header, and
+// the permissions the user has with it, into the request context. Access
+// tokens carry their permissions, while API keys have the scopes the user
+// still has.
+func (s *Service) authenticate(acceptAPIKeys bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var userID int
+		var permissions []string
+
+		if key, ok := apiKeyFromHeader(r); ok && acceptAPIKeys {
+			id, err := s.validateAPIKey(key)
+			if err != nil {
+				if errors.Is(err, errInvalidToken) {
+					_ = s.errorJSON(w, err, http.StatusUnauthorized)
+				} else {
+					_ = s.errorJSON(w, err, http.StatusInternalServerError)
+				}
+				return
+			}
+
+			userID, permissions = id.UserID, id.Permissions
+		} else {
+			tokenString, err := bearerToken(r)
+			if err != nil {
+				_ = s.errorJSON(w, err, http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := s.parseAccessToken(tokenString)
+			if err != nil {
+				_ = s.errorJSON(w, err, http.StatusUnauthorized)
+				return
+			}
+
+			userID, err = claims.UserID()
+			if err != nil {
+				_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+				return
+			}
+			permissions = claims.Permissions
+		}
+
+		user, err := s.Models.User.GetOne(userID)
+		if err != nil {
+			_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+			return
+		}
+
+		if !user.Active {
+			_ = s.errorJSON(w, errInactiveAccount, http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, permissionsContextKey, permissions)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requirePermission rejects requests of users who don't have the
+// permission. It has to run after requireUser or requireCaller.
+func (s *Service) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !contains(permissionsFromContext(r.Context()), permission) {
+				_ = s.errorJSON(w, errForbidden, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// userFromContext gets the user put into the context by requireUser.
+func userFromContext(ctx context.Context) *data.User {
+	user, _ := ctx.Value(userContextKey).(*data.User)
+	return user
+}
+
+// permissionsFromContext gets the permissions put into the context by requireUser.
+func permissionsFromContext(ctx context.Context) []string {
+	permissions, _ := ctx.Value(permissionsContextKey).([]string)
+	return permissions
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+
+	"auth/data"
+)
+
+const migrateUsage = "usage: authApp migrate up|down [steps]|status"
+
+// runMigrate runs the migrate subcommand with its args and returns the exit code.
+// up applies pending migrations, down reverts the last one or the given
+// number of them, and status lists all migrations.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	dbConn := connectToDB()
+	if dbConn == nil {
+		log.Println("Can't connect to Postgres!")
+		return 1
+	}
+	defer dbConn.Close()
+
+	migrator, err := data.NewMigrator(dbConn)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		fmt.Printf("Applied %d migrations\n", len(applied))
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		fmt.Printf("Reverted %d migrations\n", len(reverted))
+
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-30s %s\n", s.Version, s.Name, applied)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// migrateUp applies pending migrations on start of the service. Replicas
+// starting together wait for each other on the migration lock.
+func migrateUp(db *sqlx.DB) error {
+	migrator, err := data.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		return fmt.Errorf("migrating database failed: %w", err)
+	}
+
+	return nil
+}
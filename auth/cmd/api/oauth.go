@@ -0,0 +1,486 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth/data"
+)
+
+// OAuth 2.0 error codes of RFC 6749.
+const (
+	oauthInvalidRequest          = "invalid_request"
+	oauthInvalidClient           = "invalid_client"
+	oauthInvalidGrant            = "invalid_grant"
+	oauthInvalidScope            = "invalid_scope"
+	oauthUnsupportedGrantType    = "unsupported_grant_type"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+	oauthServerError             = "server_error"
+)
+
+// csrfCookie holds the CSRF token of the browser session, which the login
+// form has to post back.
+const csrfCookie = "auth_csrf"
+
+var errInvalidCSRFToken = errors.New("the sign in form expired, please try again")
+
+// authorizeRequest holds parameters of the authorization request. They are
+// read from the query string first, and then from the hidden fields of the
+// login form, so the login keeps them.
+type authorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func readAuthorizeRequest(r *http.Request) authorizeRequest {
+	return authorizeRequest{
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		ResponseType:        r.FormValue("response_type"),
+		Scope:               r.FormValue("scope"),
+		State:               r.FormValue("state"),
+		Nonce:               r.FormValue("nonce"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+	}
+}
+
+// loginPage is the data of the login form.
+type loginPage struct {
+	authorizeRequest
+	CSRFToken    string
+	Email        string
+	NeedsCode    bool
+	Error        string
+	ErrorMessage string
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!doctype html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <title>Sign in</title>
+</head>
+<body>
+<h1>Sign in</h1>
+{{if .ErrorMessage}}<p style="color: red">{{.ErrorMessage}}</p>{{end}}
+{{if not .Error}}
+<form method="post" action="authorize">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <input type="hidden" name="client_id" value="{{.ClientID}}">
+    <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+    <input type="hidden" name="response_type" value="{{.ResponseType}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <input type="hidden" name="state" value="{{.State}}">
+    <input type="hidden" name="nonce" value="{{.Nonce}}">
+    <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+    <input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+    <p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+    <p><label>Password <input type="password" name="password" required></label></p>
+    {{if .NeedsCode}}
+    <p><label>Authentication code <input type="text" name="code" autocomplete="one-time-code"></label></p>
+    <p><label>or recovery code <input type="text" name="recovery_code"></label></p>
+    {{end}}
+    <p><button type="submit">Sign in</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// Authorize is the authorization endpoint of the authorization code flow.
+// GET shows the login form, POST checks the credentials, including the
+// second factor if the user has one, and redirects back to the client
+// with the code. PKCE with S256 is required from every client. The form
+// carries the CSRF token of the browser session, so other sites can't
+// post it.
+func (s *Service) Authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderLogin(w, http.StatusBadRequest, loginPage{Error: oauthInvalidRequest, ErrorMessage: err.Error()})
+		return
+	}
+
+	req := readAuthorizeRequest(r)
+
+	// errors about the client and redirect URI are shown to the user,
+	// since the redirect URI can't be trusted to send them back
+	client, ok := s.OIDC.Clients[req.ClientID]
+	if !ok {
+		s.renderLogin(w, http.StatusBadRequest, loginPage{Error: oauthInvalidClient, ErrorMessage: "Unknown client."})
+		return
+	}
+	if !client.allowsRedirect(req.RedirectURI) {
+		s.renderLogin(w, http.StatusBadRequest, loginPage{Error: oauthInvalidRequest, ErrorMessage: "Redirect URI is not registered for the client."})
+		return
+	}
+
+	switch {
+	case req.ResponseType != "code":
+		redirectWithError(w, r, req, oauthUnsupportedResponseType, "only the code response type is supported")
+		return
+	case !hasScope(req.Scope, oidcScopeOpenID):
+		redirectWithError(w, r, req, oauthInvalidScope, "openid scope is required")
+		return
+	case req.CodeChallenge == "" || req.CodeChallengeMethod != "S256":
+		redirectWithError(w, r, req, oauthInvalidRequest, "PKCE code challenge with S256 method is required")
+		return
+	}
+
+	csrfToken, err := s.csrfToken(w, r)
+	if err != nil {
+		redirectWithError(w, r, req, oauthServerError, "")
+		return
+	}
+
+	page := loginPage{authorizeRequest: req, CSRFToken: csrfToken}
+
+	if r.Method == http.MethodGet {
+		s.renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	page.Email = r.PostFormValue("email")
+	if !validCSRFToken(r) {
+		page.ErrorMessage = errInvalidCSRFToken.Error()
+		s.renderLogin(w, http.StatusForbidden, page)
+		return
+	}
+
+	user, status, err := s.checkLoginForm(r, &page)
+	if err != nil {
+		page.ErrorMessage = err.Error()
+		s.renderLogin(w, status, page)
+		return
+	}
+
+	code, err := data.GenerateAuthorizationCode(data.AuthorizationCode{
+		UserID:        user.ID,
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      time.Now(),
+	}, s.OIDC.CodeTTL)
+	if err != nil {
+		redirectWithError(w, r, req, oauthServerError, "")
+		return
+	}
+
+	if err := s.Models.AuthCode.Insert(*code); err != nil {
+		redirectWithError(w, r, req, oauthServerError, "")
+		return
+	}
+
+	if err := s.logRequest(
+		"authentication",
+		fmt.Sprintf("%s signed in to %s", user.Email, req.ClientID),
+	); err != nil {
+		log.Printf("Error on log request: %v\n", err)
+	}
+
+	query := url.Values{}
+	query.Set("code", code.PlainText)
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	http.Redirect(w, r, withQuery(req.RedirectURI, query), http.StatusFound)
+}
+
+// checkLoginForm checks the credentials posted with the login form the same
+// way Authenticate does, with throttling and lockout. Users with two-factor
+// authentication get the form back with the code field, until the code is given.
+func (s *Service) checkLoginForm(r *http.Request, page *loginPage) (*data.User, int, error) {
+	email := r.PostFormValue("email")
+	password := r.PostFormValue("password")
+
+	ip := s.clientIP(r)
+	if retryAfter, err := s.checkLoginAllowed(email, ip); err != nil {
+		if retryAfter > 0 {
+			return nil, http.StatusTooManyRequests, err
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	user, err := s.Models.User.GetByEmail(email)
+	if err != nil {
+		s.recordLoginFailure(email, ip)
+		return nil, http.StatusUnauthorized, errors.New("invalid credentials")
+	}
+
+	passwordIsValid, err := user.PasswordMatches(password)
+	if err != nil || !passwordIsValid {
+		s.recordLoginFailure(email, ip)
+		return nil, http.StatusUnauthorized, errors.New("invalid credentials")
+	}
+
+	if !user.Active {
+		return nil, http.StatusForbidden, errInactiveAccount
+	}
+
+	totp, err := s.Models.TwoFactor.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, http.StatusInternalServerError, err
+	}
+	if err == nil && totp.Confirmed {
+		page.NeedsCode = true
+
+		req := secondFactorRequest{
+			Code:         r.PostFormValue("code"),
+			RecoveryCode: r.PostFormValue("recovery_code"),
+		}
+		if req.Code == "" && req.RecoveryCode == "" {
+			return nil, http.StatusUnauthorized, errSecondFactorRequired
+		}
+
+		ok, err := s.verifySecondFactor(totp, req)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if !ok {
+			s.recordLoginFailure(user.Email, ip)
+			return nil, http.StatusUnauthorized, errInvalidSecondFactor
+		}
+	}
+
+	s.resetLoginFailures(user.Email, ip)
+	s.rehashPassword(user, password)
+
+	return user, http.StatusOK, nil
+}
+
+// Token is the token endpoint. It exchanges the authorization code for
+// the access and refresh tokens, the same ones /authenticate issues,
+// and the ID token.
+func (s *Service) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.oauthErrorJSON(w, http.StatusBadRequest, oauthInvalidRequest, err.Error())
+		return
+	}
+
+	if grantType := r.PostFormValue("grant_type"); grantType != "authorization_code" {
+		s.oauthErrorJSON(w, http.StatusBadRequest, oauthUnsupportedGrantType, "only authorization_code grant is supported")
+		return
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		s.oauthErrorJSON(w, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+		return
+	}
+
+	code, err := s.Models.AuthCode.Consume(r.PostFormValue("code"))
+	if err != nil {
+		s.oauthErrorJSON(w, http.StatusBadRequest, oauthInvalidGrant, "invalid or expired code")
+		return
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != r.PostFormValue("redirect_uri") {
+		s.oauthErrorJSON(w, http.StatusBadRequest, oauthInvalidGrant, "code was issued to another client or redirect URI")
+		return
+	}
+
+	if !verifyCodeChallenge(r.PostFormValue("code_verifier"), code.CodeChallenge) {
+		s.oauthErrorJSON(w, http.StatusBadRequest, oauthInvalidGrant, "code verifier does not match the challenge")
+		return
+	}
+
+	user, err := s.Models.User.GetOne(code.UserID)
+	if err != nil || !user.Active {
+		s.oauthErrorJSON(w, http.StatusBadRequest, oauthInvalidGrant, "user is not active")
+		return
+	}
+
+	tokens, err := s.issueTokens(user)
+	if err != nil {
+		log.Printf("Error on issue tokens: %v\n", err)
+		s.oauthErrorJSON(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+
+	idToken, err := s.issueIDToken(user, code)
+	if err != nil {
+		log.Printf("Error on issue ID token: %v\n", err)
+		s.oauthErrorJSON(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Pragma", "no-cache")
+
+	_ = s.writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  tokens.AccessToken,
+		"token_type":    tokens.TokenType,
+		"expires_in":    int(time.Until(tokens.AccessTokenExpiry).Seconds()),
+		"refresh_token": tokens.RefreshToken,
+		"id_token":      idToken,
+		"scope":         code.Scope,
+	}, headers)
+}
+
+// UserInfo returns claims of the owner of the access token. Access tokens
+// don't carry scopes, so all claims the provider knows are returned.
+func (s *Service) UserInfo(w http.ResponseWriter, r *http.Request) {
+	tokenString, err := bearerToken(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo"`)
+		s.oauthErrorJSON(w, http.StatusUnauthorized, oauthInvalidRequest, err.Error())
+		return
+	}
+
+	claims, err := s.parseAccessToken(tokenString)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		s.oauthErrorJSON(w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		s.oauthErrorJSON(w, http.StatusUnauthorized, "invalid_token", errInvalidToken.Error())
+		return
+	}
+
+	user, err := s.Models.User.GetOne(userID)
+	if err != nil || !user.Active {
+		s.oauthErrorJSON(w, http.StatusUnauthorized, "invalid_token", errInvalidToken.Error())
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, userClaims(user, []string{oidcScopeEmail, oidcScopeProfile}))
+}
+
+// authenticateClient finds the client of the token request. Confidential
+// clients authenticate with HTTP Basic or client_secret in the form,
+// public clients only send client_id.
+func (s *Service) authenticateClient(r *http.Request) (*oidcClient, bool) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+
+	client, found := s.OIDC.Clients[clientID]
+	if !found {
+		return nil, false
+	}
+
+	if client.ClientSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(client.ClientSecret), []byte(secret)) != 1 {
+		return nil, false
+	}
+
+	return &client, true
+}
+
+// csrfToken returns the CSRF token of the browser session, from its cookie,
+// or sets the cookie of a new one.
+func (s *Service) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) == 43 {
+		return cookie.Value, nil
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		Secure:   strings.HasPrefix(s.OIDC.Issuer, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token, nil
+}
+
+// validCSRFToken returns true if the posted form carries the CSRF token
+// of the browser session.
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue("csrf_token"))) == 1
+}
+
+// renderLogin writes the login form page.
+func (s *Service) renderLogin(w http.ResponseWriter, status int, page loginPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+
+	if err := loginTemplate.Execute(w, page); err != nil {
+		log.Printf("Error on render login page: %v\n", err)
+	}
+}
+
+// oauthErrorJSON writes OAuth 2.0 error response.
+func (s *Service) oauthErrorJSON(w http.ResponseWriter, status int, code, description string) {
+	payload := map[string]string{"error": code}
+	if description != "" {
+		payload["error_description"] = description
+	}
+
+	_ = s.writeJSON(w, status, payload)
+}
+
+// redirectWithError sends the error of the authorization request back to the client.
+func redirectWithError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code, description string) {
+	query := url.Values{}
+	query.Set("error", code)
+	if description != "" {
+		query.Set("error_description", description)
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+
+	http.Redirect(w, r, withQuery(req.RedirectURI, query), http.StatusFound)
+}
+
+// withQuery adds the query parameters to the URI, which may have its own ones.
+func withQuery(uri string, query url.Values) string {
+	separator := "?"
+	if strings.Contains(uri, "?") {
+		separator = "&"
+	}
+	return uri + separator + query.Encode()
+}
+
+// hasScope returns true if the space separated scopes have the scope.
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testClientID    = "spa"
+	testRedirectURI = "http://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([^"]*)"`)
+
+// newTestProvider sets up OIDC of the service with the public testClientID.
+func newTestProvider(t *testing.T, s *Service) {
+	t.Helper()
+
+	clients := `[{"client_id":"spa","redirect_uris":["http://app.example.com/callback","http://app.example.com/other"]}]`
+	provider, err := newOIDCProvider("http://auth.example.com", nil, clients, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("creating provider: %v", err)
+	}
+	s.OIDC = provider
+}
+
+// authorizeQuery gets the parameters of the authorization request with the
+// S256 challenge of testVerifier.
+func authorizeQuery() url.Values {
+	sum := sha256.Sum256([]byte(testVerifier))
+
+	query := url.Values{}
+	query.Set("client_id", testClientID)
+	query.Set("redirect_uri", testRedirectURI)
+	query.Set("response_type", "code")
+	query.Set("scope", "openid email")
+	query.Set("state", "xyz")
+	query.Set("nonce", "n-0S6_WzA2Mj")
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	query.Set("code_challenge_method", "S256")
+	return query
+}
+
+// getLoginForm gets the login form and returns the CSRF cookie and the
+// token of the form.
+func getLoginForm(t *testing.T, s *Service) (*http.Cookie, string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	s.Authorize(w, httptest.NewRequest(http.MethodGet, "/authorize?"+authorizeQuery().Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /authorize status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	match := csrfFieldPattern.FindStringSubmatch(w.Body.String())
+	if match == nil || match[1] == "" {
+		t.Fatal("login form has no CSRF token")
+	}
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == csrfCookie {
+			return cookie, match[1]
+		}
+	}
+	t.Fatal("CSRF cookie is not set")
+	return nil, ""
+}
+
+// postLoginForm posts the login form with the CSRF cookie, if any, and token.
+func postLoginForm(s *Service, cookie *http.Cookie, csrfToken, email, password string) *httptest.ResponseRecorder {
+	form := authorizeQuery()
+	form.Set("csrf_token", csrfToken)
+	form.Set("email", email)
+	form.Set("password", password)
+
+	r := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	s.Authorize(w, r)
+	return w
+}
+
+// signIn goes through the login form and returns the authorization code.
+func signIn(t *testing.T, s *Service) string {
+	t.Helper()
+
+	user := addTestUser(t, s, "ann@example.com", true)
+	cookie, csrfToken := getLoginForm(t, s)
+
+	w := postLoginForm(s, cookie, csrfToken, user.Email, testPassword)
+	if w.Code != http.StatusFound {
+		t.Fatalf("POST /authorize status = %d, want %d: %s", w.Code, http.StatusFound, w.Body)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != testRedirectURI {
+		t.Fatalf("redirected to %s, want %s", got, testRedirectURI)
+	}
+	if state := location.Query().Get("state"); state != "xyz" {
+		t.Errorf("state = %q, want %q", state, "xyz")
+	}
+
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("redirect has no code: %s", location)
+	}
+	return code
+}
+
+// exchangeCode posts the token request.
+func exchangeCode(s *Service, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	s.Token(w, r)
+	return w
+}
+
+func tokenForm(code string) url.Values {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", testClientID)
+	form.Set("code", code)
+	form.Set("redirect_uri", testRedirectURI)
+	form.Set("code_verifier", testVerifier)
+	return form
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	s := newTestService(t)
+	newTestProvider(t, s)
+
+	w := exchangeCode(s, tokenForm(signIn(t, s)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var response struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if response.AccessToken == "" || response.RefreshToken == "" {
+		t.Error("access or refresh token is missing")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(response.IDToken, claims, func(*jwt.Token) (any, error) {
+		return &s.OIDC.Key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("verifying ID token: %v", err)
+	}
+	if claims["aud"] != testClientID || claims["nonce"] != "n-0S6_WzA2Mj" || claims["email"] != "ann@example.com" {
+		t.Errorf("unexpected ID token claims: %v", claims)
+	}
+}
+
+func TestTokenRejectsInvalidExchange(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, s *Service, form url.Values)
+	}{
+		{
+			name: "wrong verifier",
+			modify: func(t *testing.T, s *Service, form url.Values) {
+				form.Set("code_verifier", strings.Repeat("a", 43))
+			},
+		},
+		{
+			name: "mismatched redirect_uri",
+			modify: func(t *testing.T, s *Service, form url.Values) {
+				form.Set("redirect_uri", "http://app.example.com/other")
+			},
+		},
+		{
+			name: "reused code",
+			modify: func(t *testing.T, s *Service, form url.Values) {
+				if w := exchangeCode(s, form); w.Code != http.StatusOK {
+					t.Fatalf("first exchange status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			newTestProvider(t, s)
+
+			form := tokenForm(signIn(t, s))
+			tt.modify(t, s, form)
+
+			w := exchangeCode(s, form)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+
+			var response map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if response["error"] != oauthInvalidGrant {
+				t.Errorf("error = %q, want %q", response["error"], oauthInvalidGrant)
+			}
+			if strings.Contains(w.Body.String(), "access_token") {
+				t.Error("tokens were issued")
+			}
+		})
+	}
+}
+
+func TestAuthorizeRequiresCSRFToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		withCookie bool
+		csrfToken  func(formToken string) string
+	}{
+		{
+			name:      "no cookie",
+			csrfToken: func(formToken string) string { return formToken },
+		},
+		{
+			name:       "wrong token",
+			withCookie: true,
+			csrfToken:  func(string) string { return strings.Repeat("x", 43) },
+		},
+		{
+			name:       "no token",
+			withCookie: true,
+			csrfToken:  func(string) string { return "" },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			newTestProvider(t, s)
+			user := addTestUser(t, s, "ann@example.com", true)
+
+			cookie, formToken := getLoginForm(t, s)
+			if !tt.withCookie {
+				cookie = nil
+			}
+
+			w := postLoginForm(s, cookie, tt.csrfToken(formToken), user.Email, testPassword)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+			}
+			if location := w.Header().Get("Location"); location != "" {
+				t.Errorf("redirected to %s", location)
+			}
+		})
+	}
+}
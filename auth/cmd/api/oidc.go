@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"auth/data"
+)
+
+// OpenID scopes the provider knows. Claims of the user are included in the
+// ID token and userinfo by the scopes the client asked for.
+const (
+	oidcScopeOpenID  = "openid"
+	oidcScopeEmail   = "email"
+	oidcScopeProfile = "profile"
+)
+
+// oidcClient is one client registered with the provider. Clients without
+// a secret are public, e.g. single page apps, and authenticate with PKCE only.
+type oidcClient struct {
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// allowsRedirect returns true if the redirect URI is one of the registered ones.
+// URIs are compared as is, as OAuth 2.0 Security BCP requires.
+func (c *oidcClient) allowsRedirect(redirectURI string) bool {
+	for _, uri := range c.RedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcProvider holds the configuration of the OpenID Connect provider.
+// ID tokens are signed with the RSA Key, which public part is served as JWKS.
+type oidcProvider struct {
+	Issuer     string
+	Key        *rsa.PrivateKey
+	KeyID      string
+	Clients    map[string]oidcClient
+	CodeTTL    time.Duration
+	IDTokenTTL time.Duration
+}
+
+// newOIDCProvider creates oidcProvider. The signing key is read from the PEM
+// encoded keyPEM, or generated if it's empty, and clients are read from the
+// JSON array clientsJSON.
+func newOIDCProvider(issuer string, keyPEM []byte, clientsJSON string, codeTTL, idTokenTTL time.Duration) (*oidcProvider, error) {
+	var key *rsa.PrivateKey
+	var err error
+	if len(keyPEM) == 0 {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		key, err = parseRSAPrivateKey(keyPEM)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	clients := map[string]oidcClient{}
+	if clientsJSON != "" {
+		var list []oidcClient
+		if err := json.Unmarshal([]byte(clientsJSON), &list); err != nil {
+			return nil, fmt.Errorf("malformed clients: %w", err)
+		}
+		for _, client := range list {
+			if client.ClientID == "" || len(client.RedirectURIs) == 0 {
+				return nil, errors.New("every client must have client_id and redirect_uris")
+			}
+			clients[client.ClientID] = client
+		}
+	}
+
+	return &oidcProvider{
+		Issuer:     strings.TrimSuffix(issuer, "/"),
+		Key:        key,
+		KeyID:      keyID(&key.PublicKey),
+		Clients:    clients,
+		CodeTTL:    codeTTL,
+		IDTokenTTL: idTokenTTL,
+	}, nil
+}
+
+// parseRSAPrivateKey reads RSA private key in PKCS #1 or PKCS #8 PEM block.
+func parseRSAPrivateKey(keyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+// keyID gets the RFC 7638 thumbprint of the public key, used as its "kid".
+func keyID(key *rsa.PublicKey) string {
+	thumbprint := fmt.Sprintf(
+		`{"e":"%s","kty":"RSA","n":"%s"}`,
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+	)
+	sum := sha256.Sum256([]byte(thumbprint))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Discovery serves the OpenID provider metadata.
+func (s *Service) Discovery(w http.ResponseWriter, _ *http.Request) {
+	issuer := s.OIDC.Issuer
+
+	_ = s.writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{oidcScopeOpenID, oidcScopeEmail, oidcScopeProfile},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"email", "email_verified", "name", "given_name", "family_name",
+		},
+	})
+}
+
+// JWKS serves the public key which verifies ID tokens.
+func (s *Service) JWKS(w http.ResponseWriter, _ *http.Request) {
+	key := s.OIDC.Key.PublicKey
+
+	_ = s.writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": s.OIDC.KeyID,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			},
+		},
+	})
+}
+
+// issueIDToken signs ID token of the user for the client which got
+// the authorization code.
+func (s *Service) issueIDToken(user *data.User, code *data.AuthorizationCode) (string, error) {
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"iss":       s.OIDC.Issuer,
+		"aud":       code.ClientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.OIDC.IDTokenTTL).Unix(),
+		"auth_time": code.AuthTime.Unix(),
+	}
+	if code.Nonce != "" {
+		claims["nonce"] = code.Nonce
+	}
+	for name, value := range userClaims(user, strings.Fields(code.Scope)) {
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.OIDC.KeyID
+
+	return token.SignedString(s.OIDC.Key)
+}
+
+// userClaims gets standard claims of the user allowed by the scopes.
+func userClaims(user *data.User, scopes []string) map[string]any {
+	claims := map[string]any{
+		"sub": strconv.Itoa(user.ID),
+	}
+
+	for _, scope := range scopes {
+		switch scope {
+		case oidcScopeEmail:
+			claims["email"] = user.Email
+			// only users who verified their email address are active
+			claims["email_verified"] = user.Active
+		case oidcScopeProfile:
+			claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+			claims["given_name"] = user.FirstName
+			claims["family_name"] = user.LastName
+			claims["updated_at"] = user.UpdatedAt.Unix()
+		}
+	}
+
+	return claims
+}
+
+// verifyCodeChallenge checks the PKCE code verifier against the S256 challenge.
+func verifyCodeChallenge(verifier, challenge string) bool {
+	// RFC 7636 allows verifiers of 43 to 128 characters
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"auth/data"
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword emails a single-use password reset token to the owner of the
+// account. The response is the same whether the account exists or not, so it
+// can't be used to find out which emails are registered.
+func (s *Service) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var reqPayload forgotPasswordRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	v := newValidator()
+	validateEmail(v, reqPayload.Email)
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if user, err := s.Models.User.GetByEmail(reqPayload.Email); err == nil {
+		if err := s.sendPasswordReset(user); err != nil {
+			log.Printf("Error on send password reset to %s: %v\n", user.Email, err)
+		}
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: "If the account exists, a password reset link has been sent",
+	})
+}
+
+// ResetPassword sets new password of the user the reset token was issued for.
+// All of the user's sessions are revoked and lockout is lifted.
+func (s *Service) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var reqPayload resetPasswordRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	v := newValidator()
+	v.Check(reqPayload.Token != "", "token", "must be provided")
+	validatePassword(v, reqPayload.Password)
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	token, err := s.Models.OneTimeToken.Consume(data.ScopePasswordReset, reqPayload.Token)
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken)
+		return
+	}
+
+	user, err := s.Models.User.GetOne(token.UserID)
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken)
+		return
+	}
+
+	if err := s.models(r).User.ResetPassword(user.ID, reqPayload.Password); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = s.errorJSON(w, errInvalidToken)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := s.Models.OneTimeToken.DeleteAllForUser(data.ScopePasswordReset, user.ID); err != nil {
+		log.Printf("Error on delete password reset tokens of user %d: %v\n", user.ID, err)
+	}
+
+	if err := s.Models.Token.DeleteAllForUser(user.ID); err != nil {
+		log.Printf("Error on revoke tokens of user %d: %v\n", user.ID, err)
+	}
+
+	if err := s.Models.LoginAttempt.Reset(scopeEmail, strings.ToLower(user.Email)); err != nil {
+		log.Printf("Error on reset login failures of user %d: %v\n", user.ID, err)
+	}
+
+	if err := s.logRequest(
+		"authentication",
+		fmt.Sprintf("%s reset password", user.Email),
+	); err != nil {
+		log.Printf("Error on log request: %v\n", err)
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: fmt.Sprintf("Password changed for user %s", user.Email),
+	})
+}
+
+// sendPasswordReset replaces password reset tokens of the user with a new one,
+// and emails it to the user with the password-reset template of the mail service.
+func (s *Service) sendPasswordReset(user *data.User) error {
+	if err := s.Models.OneTimeToken.DeleteAllForUser(data.ScopePasswordReset, user.ID); err != nil {
+		return err
+	}
+
+	token, err := data.GenerateOneTimeToken(user.ID, data.ScopePasswordReset, s.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Models.OneTimeToken.Insert(*token); err != nil {
+		return err
+	}
+
+	return s.sendMail(mailMessage{
+		To:       user.Email,
+		Subject:  "Reset your password",
+		Template: "password-reset",
+		Data: map[string]any{
+			"link":   fmt.Sprintf("%s?token=%s", s.PasswordResetURL, url.QueryEscape(token.PlainText)),
+			"token":  token.PlainText,
+			"expiry": token.Expiry.Format("2006-01-02 15:04 MST"),
+		},
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"auth/data"
+)
+
+var errOwnRoles = errors.New("you can't grant roles to yourself")
+
+type userRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+type userRolesResponse struct {
+	UserID      int      `json:"user_id"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// ListRoles returns all roles with the permissions they grant.
+func (s *Service) ListRoles(w http.ResponseWriter, _ *http.Request) {
+	roles, err := s.Models.Role.GetAll()
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("Found %d roles", len(roles)),
+		Data:    roles,
+	})
+}
+
+// GetUserRoles returns roles of one user and the permissions they grant.
+func (s *Service) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	s.writeUserRoles(w, user, "Found roles of user %s")
+}
+
+// SetUserRoles replaces roles of one user. The user gets them in the
+// access token issued on the next login or refresh. Users may give up
+// their own roles, but not grant themselves new ones.
+func (s *Service) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var reqPayload userRolesRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	if caller := userFromContext(r.Context()); caller == nil || caller.ID == user.ID {
+		current, err := s.Models.Role.GetForUser(user.ID)
+		if err != nil {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		for _, role := range reqPayload.Roles {
+			if !contains(current, role) {
+				_ = s.errorJSON(w, errOwnRoles, http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	if err := s.models(r).Role.SetForUser(user.ID, reqPayload.Roles); err != nil {
+		if errors.Is(err, data.ErrUnknownRole) {
+			_ = s.failedValidationJSON(w, map[string]string{"roles": "must contain only existing roles"})
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.writeUserRoles(w, user, "Set roles of user %s")
+}
+
+// writeUserRoles writes roles and permissions of the user to http.ResponseWriter.
+func (s *Service) writeUserRoles(w http.ResponseWriter, user *data.User, message string) {
+	roles, err := s.Models.Role.GetForUser(user.ID)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	permissions, err := s.Models.Role.GetPermissionsForUser(user.ID)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf(message, user.Email),
+		Data: userRolesResponse{
+			UserID:      user.ID,
+			Roles:       roles,
+			Permissions: permissions,
+		},
+	})
+}
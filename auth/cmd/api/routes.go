@@ -6,6 +6,8 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+
+	"auth/data"
 )
 
 // router set auth endpoints and gets http.Handler handler.
@@ -24,6 +26,67 @@ func (s *Service) router() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Post("/authenticate", s.Authenticate)
+	mux.Post("/authenticate/2fa", s.AuthenticateSecondFactor)
+	mux.Post("/refresh", s.Refresh)
+	mux.Post("/logout", s.Logout)
+	mux.Get("/validate", s.Validate)
+
+	mux.Get("/.well-known/openid-configuration", s.Discovery)
+	mux.Get("/.well-known/jwks.json", s.JWKS)
+	mux.Get("/authorize", s.Authorize)
+	mux.Post("/authorize", s.Authorize)
+	mux.Post("/token", s.Token)
+	mux.Get("/userinfo", s.UserInfo)
+	mux.Post("/userinfo", s.UserInfo)
+
+	mux.Post("/signup", s.Signup)
+	mux.Get("/verify", s.Verify)
+	mux.Post("/verify", s.Verify)
+	mux.Post("/verify/resend", s.ResendVerification)
+
+	mux.Post("/password/forgot", s.ForgotPassword)
+	mux.Post("/password/reset", s.ResetPassword)
+
+	mux.Route("/2fa", func(mux chi.Router) {
+		mux.Use(s.requireUser)
+		mux.Post("/totp/enroll", s.EnrollTOTP)
+		mux.Post("/totp/confirm", s.ConfirmTOTP)
+		mux.Post("/totp/disable", s.DisableTOTP)
+		mux.Post("/recovery-codes", s.RegenerateRecoveryCodes)
+	})
+
+	mux.Route("/api-keys", func(mux chi.Router) {
+		mux.Use(s.requireUser)
+		mux.Get("/", s.ListAPIKeys)
+		mux.Post("/", s.CreateAPIKey)
+		mux.Delete("/{id}", s.RevokeAPIKey)
+	})
+
+	// the user management API is called by the broker on behalf of its
+	// callers, who need the same permissions as for the broker actions
+	mux.Route("/roles", func(mux chi.Router) {
+		mux.Use(s.requireCaller)
+		mux.With(s.requirePermission(data.PermissionUsersRead)).Get("/", s.ListRoles)
+	})
+
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Use(s.requireCaller)
+
+		read := mux.With(s.requirePermission(data.PermissionUsersRead))
+		read.Get("/", s.ListUsers)
+		read.Get("/{id}", s.GetUser)
+		read.Get("/{id}/roles", s.GetUserRoles)
+		read.Get("/{id}/audit", s.GetUserAudit)
+
+		write := mux.With(s.requirePermission(data.PermissionUsersWrite))
+		write.Post("/", s.CreateUser)
+		write.Put("/{id}", s.UpdateUser)
+		write.Post("/{id}/password", s.SetUserPassword)
+		write.Post("/{id}/unlock", s.UnlockUser)
+
+		mux.With(s.requirePermission(data.PermissionUsersDelete)).Delete("/{id}", s.DeleteUser)
+		mux.With(s.requirePermission(data.PermissionRolesWrite)).Put("/{id}/roles", s.SetUserRoles)
+	})
 
 	return mux
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"auth/data"
+)
+
+var errInactiveAccount = errors.New("account is not active, verify your email address first")
+
+type signupRequest struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+}
+
+type verifyRequest struct {
+	Token string `json:"token"`
+}
+
+type resendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// Signup creates new inactive user and sends the verification link
+// to the user's email address.
+func (s *Service) Signup(w http.ResponseWriter, r *http.Request) {
+	var reqPayload signupRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	v := newValidator()
+	validateEmail(v, reqPayload.Email)
+	validatePassword(v, reqPayload.Password)
+	validateName(v, "first_name", reqPayload.FirstName)
+	validateName(v, "last_name", reqPayload.LastName)
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if _, err := s.Models.User.GetByEmail(reqPayload.Email); err == nil {
+		_ = s.errorJSON(w, errors.New("a user with this email address already exists"), http.StatusConflict)
+		return
+	}
+
+	id, err := s.models(r).User.Insert(data.User{
+		Email:     reqPayload.Email,
+		FirstName: reqPayload.FirstName,
+		LastName:  reqPayload.LastName,
+		Password:  reqPayload.Password,
+		Active:    false,
+	})
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.models(r).Role.SetForUser(id, []string{data.RoleUser}); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.Models.User.GetOne(id)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.sendVerification(user); err != nil {
+		log.Printf("Error on send verification to %s: %v\n", user.Email, err)
+		_ = s.errorJSON(w, errors.New("account created, but the verification email could not be sent"), http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusCreated, jsonResponse{
+		Message: fmt.Sprintf("Created user %s, check your email to activate the account", user.Email),
+		Data:    user,
+	})
+}
+
+// Verify activates the account the verification token was issued for.
+// The token is read from the "token" query parameter, so the link from the
+// email works as is, or from the JSON body.
+func (s *Service) Verify(w http.ResponseWriter, r *http.Request) {
+	plainText := r.URL.Query().Get("token")
+	if plainText == "" && r.Method == http.MethodPost {
+		var reqPayload verifyRequest
+		if err := s.readJSON(w, r, &reqPayload); err != nil {
+			_ = s.errorJSON(w, err)
+			return
+		}
+		plainText = reqPayload.Token
+	}
+
+	if plainText == "" {
+		_ = s.errorJSON(w, errors.New("verification token must be provided"))
+		return
+	}
+
+	token, err := s.Models.OneTimeToken.Consume(data.ScopeVerification, plainText)
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken)
+		return
+	}
+
+	user, err := s.Models.User.GetOne(token.UserID)
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken)
+		return
+	}
+
+	user.Active = true
+	if err := s.models(r).User.Update(*user); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.Models.OneTimeToken.DeleteAllForUser(data.ScopeVerification, user.ID); err != nil {
+		log.Printf("Error on delete verification tokens of user %d: %v\n", user.ID, err)
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: fmt.Sprintf("Activated user %s", user.Email),
+		Data:    user,
+	})
+}
+
+// ResendVerification sends a new verification link if the account with the
+// given email exists and is not active yet. The response is the same either
+// way, so it can't be used to find out which emails are registered.
+func (s *Service) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var reqPayload resendVerificationRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	if user, err := s.Models.User.GetByEmail(reqPayload.Email); err == nil && !user.Active {
+		if err := s.sendVerification(user); err != nil {
+			log.Printf("Error on send verification to %s: %v\n", user.Email, err)
+		}
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: "If the account needs verification, a new link has been sent",
+	})
+}
+
+// sendVerification replaces verification tokens of the user with a new one,
+// and emails the link with it to the user.
+func (s *Service) sendVerification(user *data.User) error {
+	if err := s.Models.OneTimeToken.DeleteAllForUser(data.ScopeVerification, user.ID); err != nil {
+		return err
+	}
+
+	token, err := data.GenerateOneTimeToken(user.ID, data.ScopeVerification, s.VerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Models.OneTimeToken.Insert(*token); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?token=%s", s.VerificationURL, url.QueryEscape(token.PlainText))
+
+	return s.sendMail(mailMessage{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Message: fmt.Sprintf(
+			"Welcome! Open the link below to activate your account. The link expires at %s.\n\n%s",
+			token.Expiry.Format("2006-01-02 15:04 MST"),
+			link,
+		),
+	})
+}
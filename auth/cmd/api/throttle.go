@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth/data"
+)
+
+const (
+	scopeEmail = "email"
+	scopeIP    = "ip"
+)
+
+var (
+	errAccountLocked   = errors.New("account is temporarily locked, try again later")
+	errTooManyAttempts = errors.New("too many failed login attempts, try again later")
+)
+
+// loginThrottle holds settings of login throttling. After every failed attempt
+// the next one is allowed only after a delay, which doubles with every failure
+// up to MaxDelay. A subject is locked out for LockoutDuration once it reaches
+// its maximum of failures.
+type loginThrottle struct {
+	MaxFailures      int
+	MaxFailuresPerIP int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutDuration  time.Duration
+}
+
+// maxFailures gets the number of failures after which subjects of the scope are locked out.
+func (t loginThrottle) maxFailures(scope string) int {
+	if scope == scopeIP {
+		return t.MaxFailuresPerIP
+	}
+	return t.MaxFailures
+}
+
+// delay gets time to wait before the next attempt after the given number of failures.
+func (t loginThrottle) delay(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+
+	d := t.BaseDelay
+	for i := 1; i < failures && d < t.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > t.MaxDelay {
+		d = t.MaxDelay
+	}
+
+	return d
+}
+
+// loginSubjects gets scopes and subjects throttled for one login attempt.
+func loginSubjects(email, ip string) map[string]string {
+	return map[string]string{
+		scopeEmail: strings.ToLower(email),
+		scopeIP:    ip,
+	}
+}
+
+// checkLoginAllowed returns an error, and the time the client should wait, if
+// login with the email from the ip is locked out or throttled at the moment.
+// Otherwise it counts the attempt as failed up front, in one statement per
+// subject, until resetLoginFailures forgets it on success. So concurrent
+// attempts can't all get past the check before the first of them fails,
+// and no more than the maximum of attempts reach the password check.
+func (s *Service) checkLoginAllowed(email, ip string) (time.Duration, error) {
+	if wait, err := s.checkLoginThrottle(email, ip); err != nil {
+		return wait, err
+	}
+
+	for scope, subject := range loginSubjects(email, ip) {
+		attempt, err := s.Models.LoginAttempt.RecordFailure(scope, subject)
+		if err != nil {
+			return 0, err
+		}
+
+		if attempt.Failures > s.Throttle.maxFailures(scope) {
+			if attempt.LockedUntil == nil {
+				s.lockOut(attempt)
+			}
+			return s.Throttle.LockoutDuration, errAccountLocked
+		}
+	}
+
+	return 0, nil
+}
+
+// checkLoginThrottle returns an error, and the time the client should wait,
+// if the subjects of login with the email from the ip are locked out, or
+// failed too recently.
+func (s *Service) checkLoginThrottle(email, ip string) (time.Duration, error) {
+	now := time.Now()
+
+	for scope, subject := range loginSubjects(email, ip) {
+		attempt, err := s.Models.LoginAttempt.Get(scope, subject)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return 0, err
+		}
+
+		if attempt.IsLocked(now) {
+			return attempt.LockedUntil.Sub(now), errAccountLocked
+		}
+
+		// lockout is over, or the last failure is too old to matter, so start from scratch
+		if attempt.LockedUntil != nil || now.Sub(attempt.LastFailureAt) > s.Throttle.LockoutDuration {
+			if err := s.Models.LoginAttempt.Reset(scope, subject); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if wait := attempt.LastFailureAt.Add(s.Throttle.delay(attempt.Failures)).Sub(now); wait > 0 {
+			return wait, errTooManyAttempts
+		}
+	}
+
+	return 0, nil
+}
+
+// recordLoginFailure settles failed login with the email from the ip, which
+// checkLoginAllowed counted already, and locks out subjects which have
+// reached their maximum of failures.
+func (s *Service) recordLoginFailure(email, ip string) {
+	for scope, subject := range loginSubjects(email, ip) {
+		attempt, err := s.Models.LoginAttempt.Get(scope, subject)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Error on record login failure: %v\n", err)
+			}
+			continue
+		}
+
+		if attempt.Failures < s.Throttle.maxFailures(scope) || attempt.LockedUntil != nil {
+			continue
+		}
+
+		s.lockOut(attempt)
+	}
+}
+
+// lockOut locks the subject of the attempt out for LockoutDuration.
+func (s *Service) lockOut(attempt *data.LoginAttempt) {
+	until := time.Now().Add(s.Throttle.LockoutDuration)
+	if err := s.Models.LoginAttempt.Lock(attempt.Scope, attempt.Subject, until); err != nil {
+		log.Printf("Error on lock out %s %s: %v\n", attempt.Scope, attempt.Subject, err)
+		return
+	}
+
+	if err := s.logRequest(
+		"authentication",
+		fmt.Sprintf("%s %s locked out until %s after %d failed logins", attempt.Scope, attempt.Subject, until.Format(time.RFC3339), attempt.Failures),
+	); err != nil {
+		log.Printf("Error on log request: %v\n", err)
+	}
+}
+
+// resetLoginFailures forgets failed logins with the email from the ip after successful login.
+func (s *Service) resetLoginFailures(email, ip string) {
+	for scope, subject := range loginSubjects(email, ip) {
+		if err := s.Models.LoginAttempt.Reset(scope, subject); err != nil {
+			log.Printf("Error on reset login failures: %v\n", err)
+		}
+	}
+}
+
+// UnlockUser lifts lockout of one user and forgets the user's failed logins.
+func (s *Service) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.Models.LoginAttempt.Reset(scopeEmail, strings.ToLower(user.Email)); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.logRequest(
+		"authentication",
+		fmt.Sprintf("%s unlocked", user.Email),
+	); err != nil {
+		log.Printf("Error on log request: %v\n", err)
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: fmt.Sprintf("Unlocked user %s", user.Email),
+	})
+}
+
+// tooManyRequestsJSON writes throttling error with Retry-After header to http.ResponseWriter.
+func (s *Service) tooManyRequestsJSON(w http.ResponseWriter, err error, retryAfter time.Duration) error {
+	seconds := int(retryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	headers := http.Header{}
+	headers.Set("Retry-After", fmt.Sprint(seconds))
+
+	return s.writeJSON(w, http.StatusTooManyRequests, jsonResponse{
+		Error:   true,
+		Message: err.Error(),
+	}, headers)
+}
+
+// clientIP gets IP address of the client which made the request. Only
+// trusted proxies, such as the broker, may tell the address of the client
+// they forward the request for in X-Real-IP; the header is ignored when
+// anybody else sends it.
+func (s *Service) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !isTrustedProxy(s.TrustedProxies, ip) {
+		return ip
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+// remoteIP gets IP address of the peer which made the request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseTrustedProxies reads the comma separated IP addresses and CIDR
+// networks of trusted proxies.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an IP address or network", item)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP address or network", item)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// isTrustedProxy returns true if the address is in one of the networks.
+func isTrustedProxy(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
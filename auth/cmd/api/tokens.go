@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"auth/data"
+)
+
+const (
+	tokenIssuer = "auth"
+	tokenType   = "Bearer"
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// tokenPair is the pair of tokens handed out to the client after successful
+// authentication or refresh.
+type tokenPair struct {
+	AccessToken        string    `json:"access_token"`
+	AccessTokenExpiry  time.Time `json:"access_token_expiry"`
+	RefreshToken       string    `json:"refresh_token"`
+	RefreshTokenExpiry time.Time `json:"refresh_token_expiry"`
+	TokenType          string    `json:"token_type"`
+	Roles              []string  `json:"roles"`
+	Permissions        []string  `json:"permissions"`
+}
+
+// accessClaims is the set of claims signed into the access token. Roles and
+// permissions are the ones the user had when the token was issued, so changes
+// take effect once the token is refreshed.
+type accessClaims struct {
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	jwt.RegisteredClaims
+}
+
+// UserID gets user's id from the subject claim.
+func (c *accessClaims) UserID() (int, error) {
+	return strconv.Atoi(c.Subject)
+}
+
+// issueTokens creates signed access token and stores new refresh token
+// for the given user.
+func (s *Service) issueTokens(user *data.User) (*tokenPair, error) {
+	roles, err := s.Models.Role.GetForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := s.Models.Role.GetPermissionsForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	accessExpiry := now.Add(s.AccessTokenTTL)
+
+	claims := accessClaims{
+		Email:       user.Email,
+		Roles:       roles,
+		Permissions: permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(accessExpiry),
+		},
+	}
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).
+		SignedString(s.JWTSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := data.GenerateToken(user.ID, s.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Models.Token.Insert(*refreshToken); err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{
+		AccessToken:        accessToken,
+		AccessTokenExpiry:  accessExpiry,
+		RefreshToken:       refreshToken.PlainText,
+		RefreshTokenExpiry: refreshToken.Expiry,
+		TokenType:          tokenType,
+		Roles:              roles,
+		Permissions:        permissions,
+	}, nil
+}
+
+// parseAccessToken validates signature and expiry of the access token
+// and gets its claims.
+func (s *Service) parseAccessToken(tokenString string) (*accessClaims, error) {
+	var claims accessClaims
+
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(t *jwt.Token) (any, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return s.JWTSecret, nil
+		},
+	)
+	if err != nil || !token.Valid {
+		return nil, errInvalidToken
+	}
+
+	if !claims.VerifyIssuer(tokenIssuer, true) {
+		return nil, errInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// bearerToken gets token from the Authorization header of the request.
+func bearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("no authorization header received")
+	}
+
+	headerParts := strings.Split(authHeader, " ")
+	if len(headerParts) != 2 || !strings.EqualFold(headerParts[0], tokenType) {
+		return "", errors.New("malformed authorization header")
+	}
+
+	return headerParts[1], nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"auth/data"
+)
+
+var (
+	errSecondFactorRequired = errors.New("second factor required")
+	errInvalidSecondFactor  = errors.New("invalid authentication code")
+	errTOTPEnabled          = errors.New("two-factor authentication is already enabled")
+	errTOTPNotEnrolled      = errors.New("two-factor authentication is not enrolled")
+	errTOTPNotEnabled       = errors.New("two-factor authentication is not enabled")
+)
+
+// secondFactorRequest holds either a TOTP code or a recovery code.
+type secondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type secondFactorLoginRequest struct {
+	Challenge string `json:"challenge"`
+	secondFactorRequest
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"password"`
+	secondFactorRequest
+}
+
+// challengeResponse is returned by Authenticate instead of tokens when the
+// user has to pass the second factor. The challenge is exchanged for the
+// tokens at /authenticate/2fa together with the code.
+type challengeResponse struct {
+	SecondFactorRequired bool      `json:"second_factor_required"`
+	Challenge            string    `json:"challenge"`
+	ChallengeExpiry      time.Time `json:"challenge_expiry"`
+	Methods              []string  `json:"methods"`
+}
+
+type enrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// AuthenticateSecondFactor completes the login started by Authenticate
+// with the challenge and a TOTP or recovery code, and issues the tokens.
+// Wrong codes count as failed logins, so they are throttled the same way.
+func (s *Service) AuthenticateSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var reqPayload secondFactorLoginRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	challenge, err := s.Models.OneTimeToken.Get(data.ScopeMFAChallenge, reqPayload.Challenge)
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.Models.User.GetOne(challenge.UserID)
+	if err != nil {
+		_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	ip := s.clientIP(r)
+	if retryAfter, err := s.checkLoginAllowed(user.Email, ip); err != nil {
+		if retryAfter > 0 {
+			_ = s.tooManyRequestsJSON(w, err, retryAfter)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	totp, err := s.Models.TwoFactor.GetTOTP(user.ID)
+	if err != nil || !totp.Confirmed {
+		_ = s.errorJSON(w, errTOTPNotEnabled)
+		return
+	}
+
+	ok, err := s.verifySecondFactor(totp, reqPayload.secondFactorRequest)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		s.recordLoginFailure(user.Email, ip)
+		_ = s.errorJSON(w, errInvalidSecondFactor)
+		return
+	}
+
+	// the challenge works only once
+	if _, err := s.Models.OneTimeToken.Consume(data.ScopeMFAChallenge, reqPayload.Challenge); err != nil {
+		_ = s.errorJSON(w, errInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	s.completeLogin(w, user, ip)
+}
+
+// EnrollTOTP generates new TOTP secret for the authenticated user and returns
+// it with the provisioning URI. The secret is not required on login until
+// it is confirmed with a code at /2fa/totp/confirm.
+func (s *Service) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	if totp, err := s.Models.TwoFactor.GetTOTP(user.ID); err == nil && totp.Confirmed {
+		_ = s.errorJSON(w, errTOTPEnabled, http.StatusConflict)
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.models(r).TwoFactor.SaveTOTP(user.ID, secret); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: "Add the secret to your authenticator app and confirm it with a code",
+		Data: enrollResponse{
+			Secret: secret,
+			URI:    totpURI(s.TOTPIssuer, user.Email, secret),
+		},
+	})
+}
+
+// ConfirmTOTP enables the enrolled TOTP of the authenticated user after
+// checking the first code, and returns new recovery codes.
+func (s *Service) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	var reqPayload secondFactorRequest
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	totp, err := s.Models.TwoFactor.GetTOTP(user.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = s.errorJSON(w, errTOTPNotEnrolled)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if totp.Confirmed {
+		_ = s.errorJSON(w, errTOTPEnabled, http.StatusConflict)
+		return
+	}
+
+	// recovery codes don't exist until the TOTP is confirmed
+	ok, err := s.verifySecondFactor(totp, secondFactorRequest{Code: reqPayload.Code})
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		_ = s.errorJSON(w, errInvalidSecondFactor)
+		return
+	}
+
+	if err := s.models(r).TwoFactor.ConfirmTOTP(user.ID); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := s.replaceRecoveryCodes(r, user.ID)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.logRequest(
+		"two-factor",
+		fmt.Sprintf("%s enabled two-factor authentication", user.Email),
+	); err != nil {
+		log.Printf("Error on log request: %v\n", err)
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: "Two-factor authentication enabled, keep the recovery codes in a safe place",
+		Data:    recoveryCodesResponse{RecoveryCodes: codes},
+	})
+}
+
+// DisableTOTP turns off two-factor authentication of the authenticated user.
+// Both the password and a TOTP or recovery code are required, and wrong
+// ones count as failed logins.
+func (s *Service) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	var reqPayload disableTOTPRequest
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	ip := s.clientIP(r)
+	if retryAfter, err := s.checkLoginAllowed(user.Email, ip); err != nil {
+		if retryAfter > 0 {
+			_ = s.tooManyRequestsJSON(w, err, retryAfter)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	passwordIsValid, err := user.PasswordMatches(reqPayload.Password)
+	if err != nil || !passwordIsValid {
+		s.recordLoginFailure(user.Email, ip)
+		_ = s.errorJSON(w, errors.New("invalid credentials"))
+		return
+	}
+
+	totp, err := s.Models.TwoFactor.GetTOTP(user.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.resetLoginFailures(user.Email, ip)
+			_ = s.errorJSON(w, errTOTPNotEnabled)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// an enrollment which was never confirmed can be dropped with the password only
+	if totp.Confirmed {
+		ok, err := s.verifySecondFactor(totp, reqPayload.secondFactorRequest)
+		if err != nil {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			s.recordLoginFailure(user.Email, ip)
+			_ = s.errorJSON(w, errInvalidSecondFactor)
+			return
+		}
+	}
+	s.resetLoginFailures(user.Email, ip)
+
+	if err := s.models(r).TwoFactor.DeleteTOTP(user.ID); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.logRequest(
+		"two-factor",
+		fmt.Sprintf("%s disabled two-factor authentication", user.Email),
+	); err != nil {
+		log.Printf("Error on log request: %v\n", err)
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces recovery codes of the authenticated user
+// after checking a TOTP code. The old codes stop working.
+func (s *Service) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	var reqPayload secondFactorRequest
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	totp, err := s.Models.TwoFactor.GetTOTP(user.ID)
+	if err != nil || !totp.Confirmed {
+		_ = s.errorJSON(w, errTOTPNotEnabled)
+		return
+	}
+
+	ok, err := s.verifySecondFactor(totp, secondFactorRequest{Code: reqPayload.Code})
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		_ = s.errorJSON(w, errInvalidSecondFactor)
+		return
+	}
+
+	codes, err := s.replaceRecoveryCodes(r, user.ID)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: "Generated new recovery codes",
+		Data:    recoveryCodesResponse{RecoveryCodes: codes},
+	})
+}
+
+// issueChallenge stores new login challenge of the user and writes it instead
+// of the tokens.
+func (s *Service) issueChallenge(w http.ResponseWriter, user *data.User) {
+	challenge, err := data.GenerateOneTimeToken(user.ID, data.ScopeMFAChallenge, s.MFAChallengeTTL)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.Models.OneTimeToken.Insert(*challenge); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: errSecondFactorRequired.Error(),
+		Data: challengeResponse{
+			SecondFactorRequired: true,
+			Challenge:            challenge.PlainText,
+			ChallengeExpiry:      challenge.Expiry,
+			Methods:              []string{"totp", "recovery_code"},
+		},
+	})
+}
+
+// verifySecondFactor checks the recovery code, if given, or the TOTP code.
+// Used codes are recorded, so none of them works twice.
+func (s *Service) verifySecondFactor(totp *data.TOTP, req secondFactorRequest) (bool, error) {
+	if req.RecoveryCode != "" {
+		return s.Models.TwoFactor.UseRecoveryCode(totp.UserID, req.RecoveryCode)
+	}
+
+	step, ok := validateTOTP(totp.Secret, req.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return s.Models.TwoFactor.UseStep(totp.UserID, step)
+}
+
+// replaceRecoveryCodes generates and stores new recovery codes of the user.
+func (s *Service) replaceRecoveryCodes(r *http.Request, userID int) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.models(r).TwoFactor.ReplaceRecoveryCodes(userID, codes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisableTOTPLocksOutAfterMaxFailures(t *testing.T) {
+	s := newTestService(t)
+	s.Throttle.BaseDelay = 0
+	s.Throttle.MaxDelay = 0
+	user := addTestUser(t, s, "ann@example.com", true)
+
+	disable := func(password string) int {
+		body, _ := json.Marshal(disableTOTPRequest{Password: password})
+		r := httptest.NewRequest(http.MethodDelete, "/2fa/totp", bytes.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+
+		w := httptest.NewRecorder()
+		s.DisableTOTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < s.Throttle.MaxFailures; i++ {
+		if status := disable("wrong password"); status != http.StatusBadRequest {
+			t.Fatalf("failure %d: status = %d, want %d", i+1, status, http.StatusBadRequest)
+		}
+	}
+
+	if status := disable(testPassword); status != http.StatusTooManyRequests {
+		t.Fatalf("status after lockout = %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
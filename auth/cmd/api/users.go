@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"auth/data"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errUserNotFound = errors.New("user not found")
+
+type createUserRequest struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+	Active    bool   `json:"active"`
+}
+
+type updateUserRequest struct {
+	Email     *string `json:"email"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Active    *bool   `json:"active"`
+}
+
+type passwordRequest struct {
+	Password string `json:"password"`
+}
+
+// pageMetadata describes one page of a paginated list.
+type pageMetadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+type usersPage struct {
+	Users    []*data.User `json:"users"`
+	Metadata pageMetadata `json:"metadata"`
+}
+
+// ListUsers returns one page of users. Page and page size are read
+// from "page" and "page_size" query parameters.
+func (s *Service) ListUsers(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+
+	page, pageSize := readPageQuery(r, v)
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	users, total, err := s.Models.User.GetPage(pageSize, (page-1)*pageSize)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("Found %d users", total),
+		Data: usersPage{
+			Users:    users,
+			Metadata: newPageMetadata(page, pageSize, total),
+		},
+	})
+}
+
+// GetUser returns one user by id.
+func (s *Service) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("Found user %s", user.Email),
+		Data:    user,
+	})
+}
+
+// CreateUser validates input and creates new user.
+func (s *Service) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var reqPayload createUserRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	v := newValidator()
+	validateEmail(v, reqPayload.Email)
+	validatePassword(v, reqPayload.Password)
+	validateName(v, "first_name", reqPayload.FirstName)
+	validateName(v, "last_name", reqPayload.LastName)
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if _, err := s.Models.User.GetByEmail(reqPayload.Email); err == nil {
+		_ = s.errorJSON(w, errors.New("a user with this email address already exists"), http.StatusConflict)
+		return
+	}
+
+	id, err := s.models(r).User.Insert(data.User{
+		Email:     reqPayload.Email,
+		FirstName: reqPayload.FirstName,
+		LastName:  reqPayload.LastName,
+		Password:  reqPayload.Password,
+		Active:    reqPayload.Active,
+	})
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.models(r).Role.SetForUser(id, []string{data.RoleUser}); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.Models.User.GetOne(id)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusCreated, jsonResponse{
+		Message: fmt.Sprintf("Created user %s", user.Email),
+		Data:    user,
+	})
+}
+
+// UpdateUser changes fields of one user. Fields missing from the request are left as is.
+func (s *Service) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var reqPayload updateUserRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	if reqPayload.Email != nil {
+		user.Email = *reqPayload.Email
+	}
+	if reqPayload.FirstName != nil {
+		user.FirstName = *reqPayload.FirstName
+	}
+	if reqPayload.LastName != nil {
+		user.LastName = *reqPayload.LastName
+	}
+	if reqPayload.Active != nil {
+		user.Active = *reqPayload.Active
+	}
+
+	v := newValidator()
+	validateEmail(v, user.Email)
+	validateName(v, "first_name", user.FirstName)
+	validateName(v, "last_name", user.LastName)
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if reqPayload.Email != nil {
+		if existing, err := s.Models.User.GetByEmail(user.Email); err == nil && existing.ID != user.ID {
+			_ = s.errorJSON(w, errors.New("a user with this email address already exists"), http.StatusConflict)
+			return
+		}
+	}
+
+	if err := s.models(r).User.Update(*user); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("Updated user %s", user.Email),
+		Data:    user,
+	})
+}
+
+// DeleteUser deletes one user by id.
+func (s *Service) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.models(r).User.Delete(user.ID); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("Deleted user %s", user.Email),
+	})
+}
+
+// SetUserPassword sets new password of one user and revokes all of
+// the user's refresh tokens. Callers can't set password of users who
+// have permissions the callers lack, unless they may grant roles.
+func (s *Service) SetUserPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	allowed, err := s.canManageUser(r.Context(), user)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		_ = s.errorJSON(w, errForbidden, http.StatusForbidden)
+		return
+	}
+
+	var reqPayload passwordRequest
+
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	v := newValidator()
+	validatePassword(v, reqPayload.Password)
+
+	if !v.Valid() {
+		_ = s.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if err := s.models(r).User.ResetPassword(user.ID, reqPayload.Password); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = s.errorJSON(w, errUserNotFound, http.StatusNotFound)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := s.Models.Token.DeleteAllForUser(user.ID); err != nil {
+		log.Printf("Error on revoke tokens of user %d: %v\n", user.ID, err)
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: fmt.Sprintf("Password changed for user %s", user.Email),
+	})
+}
+
+// canManageUser returns true if the caller in the context has all the
+// permissions of the user. Holders of roles:write can grant themselves any
+// of them anyway, so they may manage everyone.
+func (s *Service) canManageUser(ctx context.Context, user *data.User) (bool, error) {
+	callerPermissions := permissionsFromContext(ctx)
+	if contains(callerPermissions, data.PermissionRolesWrite) {
+		return true, nil
+	}
+
+	permissions, err := s.Models.Role.GetPermissionsForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, permission := range permissions {
+		if !contains(callerPermissions, permission) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// userFromURL gets the user whose id is in the URL. If the id is malformed
+// or there is no such user, it writes error response and returns false.
+func (s *Service) userFromURL(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		_ = s.errorJSON(w, errors.New("invalid id parameter"))
+		return nil, false
+	}
+
+	user, err := s.Models.User.GetOne(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = s.errorJSON(w, errUserNotFound, http.StatusNotFound)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// readPageQuery reads page number and page size from "page" and "page_size"
+// query parameters. Malformed and out of range values are recorded in validator v.
+func readPageQuery(r *http.Request, v *validator) (int, int) {
+	page := readIntQuery(r, "page", 1, v)
+	pageSize := readIntQuery(r, "page_size", defaultPageSize, v)
+
+	v.Check(page > 0, "page", "must be greater than zero")
+	v.Check(page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(pageSize > 0, "page_size", "must be greater than zero")
+	v.Check(pageSize <= maxPageSize, "page_size", fmt.Sprintf("must be a maximum of %d", maxPageSize))
+
+	return page, pageSize
+}
+
+// newPageMetadata describes the page of the list of total records.
+func newPageMetadata(page, pageSize, total int) pageMetadata {
+	lastPage := (total + pageSize - 1) / pageSize
+	if lastPage == 0 {
+		lastPage = 1
+	}
+
+	return pageMetadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     lastPage,
+		TotalRecords: total,
+	}
+}
+
+// readIntQuery reads integer from query string parameter key, or returns def
+// when it is missing. Malformed values are recorded in validator v.
+func readIntQuery(r *http.Request, key string, def int, v *validator) int {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return def
+	}
+
+	return i
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"auth/data"
+)
+
+func TestSetUserPasswordRequiresPermissionsOfUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		roles       []string
+		permissions []string
+		want        int
+	}{
+		{
+			name:        "user",
+			roles:       []string{data.RoleUser},
+			permissions: []string{data.PermissionUsersWrite},
+			want:        http.StatusForbidden,
+		},
+		{
+			name:        "user with the same permissions",
+			roles:       []string{data.RoleUser},
+			permissions: []string{data.PermissionUsersWrite, "logs:write", "mail:send"},
+			want:        http.StatusAccepted,
+		},
+		{
+			name:        "admin",
+			roles:       []string{data.RoleAdmin},
+			permissions: []string{data.PermissionUsersWrite},
+			want:        http.StatusForbidden,
+		},
+		{
+			name:        "admin by roles:write holder",
+			roles:       []string{data.RoleAdmin},
+			permissions: []string{data.PermissionUsersWrite, data.PermissionRolesWrite},
+			want:        http.StatusAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			user := addTestUser(t, s, "ann@example.com", true)
+			if err := s.Models.Role.SetForUser(user.ID, tt.roles); err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := json.Marshal(passwordRequest{Password: "new correct horse battery"})
+			r := httptest.NewRequest(http.MethodPost, "/users/"+strconv.Itoa(user.ID)+"/password", bytes.NewReader(body))
+
+			routeContext := chi.NewRouteContext()
+			routeContext.URLParams.Add("id", strconv.Itoa(user.ID))
+			ctx := context.WithValue(r.Context(), chi.RouteCtxKey, routeContext)
+			ctx = context.WithValue(ctx, permissionsContextKey, tt.permissions)
+
+			w := httptest.NewRecorder()
+			s.SetUserPassword(w, r.WithContext(ctx))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			after, err := s.Models.User.GetOne(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := after.Password != user.Password; changed != (tt.want == http.StatusAccepted) {
+				t.Errorf("password changed = %v", changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+)
+
+var emailRX = regexp.MustCompile(
+	"^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$",
+)
+
+// validator collects validation errors keyed by the name of invalid field.
+type validator struct {
+	Errors map[string]string
+}
+
+// newValidator creates validator with empty errors map.
+func newValidator() *validator {
+	return &validator{Errors: make(map[string]string)}
+}
+
+// Valid returns true if there are no errors.
+func (v *validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError adds error message for the key, unless it already has one.
+func (v *validator) AddError(key, message string) {
+	if _, exists := v.Errors[key]; !exists {
+		v.Errors[key] = message
+	}
+}
+
+// Check adds error message for the key if ok is false.
+func (v *validator) Check(ok bool, key, message string) {
+	if !ok {
+		v.AddError(key, message)
+	}
+}
+
+// validateEmail checks the email address is well-formed and fits the users table.
+func validateEmail(v *validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(len(email) <= 40, "email", "must not be more than 40 bytes long")
+	v.Check(emailRX.MatchString(email), "email", "must be a valid email address")
+}
+
+// validatePassword checks the password length, bcrypt ignores everything past 72 bytes.
+func validatePassword(v *validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+// validateName checks first or last name fits the users table.
+func validateName(v *validator, key, name string) {
+	v.Check(len(name) <= 255, key, "must not be more than 255 bytes long")
+}
+
+// failedValidationJSON writes validation errors to http.ResponseWriter.
+func (s *Service) failedValidationJSON(w http.ResponseWriter, errs map[string]string) error {
+	payload := jsonResponse{
+		Error:   true,
+		Message: "invalid input",
+		Data:    errs,
+	}
+
+	return s.writeJSON(w, http.StatusUnprocessableEntity, payload)
+}
@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognize.
+const apiKeyPrefix = "ak_"
+
+// Audit trail actions of API keys.
+const (
+	AuditAPIKeyCreate = "api_key.create"
+	AuditAPIKeyRevoke = "api_key.revoke"
+)
+
+// APIKey is the structure which holds one API key from the database.
+// The key acts on behalf of its user, limited to its scopes, which are
+// names of permissions. Only the SHA-256 hash of the key is stored, and
+// Prefix, the first characters of the key, tells keys apart in lists.
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	PlainText  string     `json:"key,omitempty" db:"-"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	Hash       []byte     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"-"`
+	ScopeList  string     `json:"-" db:"scopes"`
+	Expiry     *time.Time `json:"expiry,omitempty" db:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// APIKeyRepository is the interface of the storage which holds API keys.
+type APIKeyRepository interface {
+	// Insert puts new API key to the storage and returns its id.
+	Insert(key APIKey) (int, error)
+	// GetAllForUser returns all API keys of the user with the given id.
+	GetAllForUser(userID int) ([]*APIKey, error)
+	// GetByPlainText returns one not expired API key by its plain text.
+	GetByPlainText(plainText string) (*APIKey, error)
+	// Touch records the time the API key was last used.
+	Touch(id int) error
+	// Delete revokes API key with the given id of the user with the given id.
+	// It returns sql.ErrNoRows if the user has no such key.
+	Delete(id, userID int) error
+}
+
+// postgresAPIKeyRepository is the APIKeyRepository backed by Postgres.
+type postgresAPIKeyRepository struct {
+	db      *sqlx.DB
+	auditor Auditor
+}
+
+// NewAPIKeyRepository creates APIKeyRepository which stores API keys in
+// Postgres, with changes recorded by auditor.
+func NewAPIKeyRepository(db *sqlx.DB, auditor Auditor) APIKeyRepository {
+	return &postgresAPIKeyRepository{db: db, auditor: auditor}
+}
+
+// GenerateAPIKey creates a new random API key with the scopes for the user
+// with the given id. Keys with zero ttl never expire. The key is not saved
+// to the database.
+func GenerateAPIKey(userID int, name string, scopes []string, ttl time.Duration) (*APIKey, error) {
+	plainText, err := randomPlainText()
+	if err != nil {
+		return nil, err
+	}
+	plainText = apiKeyPrefix + plainText
+
+	key := &APIKey{
+		UserID:    userID,
+		Name:      name,
+		PlainText: plainText,
+		Prefix:    plainText[:len(apiKeyPrefix)+6],
+		Hash:      hashToken(plainText),
+		Scopes:    scopes,
+	}
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		key.Expiry = &expiry
+	}
+
+	return key, nil
+}
+
+// Insert puts new API key to the database and returns its id.
+func (r *postgresAPIKeyRepository) Insert(key APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	key.CreatedAt = time.Now()
+
+	args := []any{
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		strings.Join(key.Scopes, " "),
+		key.Expiry,
+		key.CreatedAt,
+	}
+	if err := tx.GetContext(ctx, &key.ID, insertAPIKeyQuery, args...); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return 0, err
+	}
+
+	// PlainText is dropped by omitempty, so the key never gets into the trail
+	key.PlainText = ""
+	event, err := r.auditor.record(ctx, tx, key.UserID, AuditAPIKeyCreate, nil, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	r.auditor.publish(event)
+
+	return key.ID, nil
+}
+
+// GetAllForUser returns all API keys of the user with the given id, newest first.
+func (r *postgresAPIKeyRepository) GetAllForUser(userID int) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	keys := []*APIKey{}
+	if err := r.db.SelectContext(ctx, &keys, getAPIKeysForUserQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	for _, key := range keys {
+		key.Scopes = strings.Fields(key.ScopeList)
+	}
+
+	return keys, nil
+}
+
+// GetByPlainText returns one not expired API key by its plain text.
+func (r *postgresAPIKeyRepository) GetByPlainText(plainText string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var key APIKey
+	if err := r.db.GetContext(ctx, &key, getAPIKeyByHashQuery, hashToken(plainText), time.Now()); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+	key.Scopes = strings.Fields(key.ScopeList)
+
+	return &key, nil
+}
+
+// Touch records the time the API key was last used.
+func (r *postgresAPIKeyRepository) Touch(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, touchAPIKeyQuery, time.Now(), id); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Delete revokes API key with the given id of the user with the given id.
+func (r *postgresAPIKeyRepository) Delete(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var key APIKey
+	if err := tx.GetContext(ctx, &key, deleteAPIKeyQuery, id, userID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Query failed: %v\n", err)
+		}
+		return err
+	}
+	key.Scopes = strings.Fields(key.ScopeList)
+
+	event, err := r.auditor.record(ctx, tx, userID, AuditAPIKeyRevoke, key, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.auditor.publish(event)
+
+	return nil
+}
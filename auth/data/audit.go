@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Actions recorded in the audit trail.
+const (
+	AuditUserCreate    = "user.create"
+	AuditUserUpdate    = "user.update"
+	AuditUserDelete    = "user.delete"
+	AuditUserPassword  = "user.password"
+	AuditUserRoles     = "user.roles"
+	AuditTOTPEnroll    = "totp.enroll"
+	AuditTOTPConfirm   = "totp.confirm"
+	AuditTOTPDisable   = "totp.disable"
+	AuditRecoveryCodes = "recovery_codes.replace"
+)
+
+// Actor is whoever makes the changes: the authenticated user, if known,
+// and the client address. Zero Actor stands for the service itself.
+type Actor struct {
+	UserID int
+	IP     string
+}
+
+// AuditEvent is one record of the append-only audit trail. Before and
+// After hold JSON of the changed values, and are null for created and
+// deleted records respectively.
+type AuditEvent struct {
+	ID        int64          `json:"id" db:"id"`
+	UserID    int            `json:"user_id" db:"user_id"`
+	ActorID   *int           `json:"actor_id" db:"actor_id"`
+	ActorIP   string         `json:"actor_ip,omitempty" db:"actor_ip"`
+	Action    string         `json:"action" db:"action"`
+	Before    types.JSONText `json:"before" db:"before"`
+	After     types.JSONText `json:"after" db:"after"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+// AuditPublisher passes recorded audit events on, e.g. to the logger.
+type AuditPublisher interface {
+	PublishAudit(event AuditEvent) error
+}
+
+// AuditRepository is the interface of the storage which holds the audit trail.
+// The trail is only appended to by the other repositories, so it has no
+// methods to change it.
+type AuditRepository interface {
+	// GetPageForUser returns one page of events of the user with the given id,
+	// newest first, with the total number of the user's events.
+	GetPageForUser(userID, limit, offset int) ([]*AuditEvent, int, error)
+	// GetUnpublished returns up to limit events recorded before the given
+	// time which weren't published yet, oldest first.
+	GetUnpublished(before time.Time, limit int) ([]*AuditEvent, error)
+	// MarkPublished records that the event with the given id was published.
+	MarkPublished(id int64) error
+}
+
+// postgresAuditRepository is the AuditRepository backed by Postgres.
+type postgresAuditRepository struct {
+	db *sqlx.DB
+}
+
+// NewAuditRepository creates AuditRepository which reads the audit trail from Postgres.
+func NewAuditRepository(db *sqlx.DB) AuditRepository {
+	return &postgresAuditRepository{db: db}
+}
+
+// GetPageForUser returns one page of events of the user with the given id.
+func (r *postgresAuditRepository) GetPageForUser(userID, limit, offset int) ([]*AuditEvent, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var total int
+	if err := r.db.GetContext(ctx, &total, countAuditEventsQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, 0, err
+	}
+
+	events := []*AuditEvent{}
+	if err := r.db.SelectContext(ctx, &events, getAuditEventsPageQuery, userID, limit, offset); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// GetUnpublished returns up to limit events recorded before the given
+// time which weren't published yet, oldest first.
+func (r *postgresAuditRepository) GetUnpublished(before time.Time, limit int) ([]*AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	events := []*AuditEvent{}
+	if err := r.db.SelectContext(ctx, &events, getUnpublishedAuditEventsQuery, before, limit); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// MarkPublished removes the event with the given id from the outbox.
+func (r *postgresAuditRepository) MarkPublished(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, deleteAuditOutboxQuery, id); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Auditor records changes made by Actor in the audit trail, in the same
+// transaction as the changes, and publishes them once they are committed.
+// Recorded events are put in the outbox until they are published, so the
+// ones which fail to publish are published later.
+type Auditor struct {
+	Actor     Actor
+	Publisher AuditPublisher
+
+	db *sqlx.DB
+}
+
+// record appends the event of the change to the audit trail in the transaction.
+func (a Auditor) record(ctx context.Context, tx *sqlx.Tx, userID int, action string, before, after any) (*AuditEvent, error) {
+	beforeJSON, err := json.Marshal(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return nil, err
+	}
+
+	event := AuditEvent{
+		UserID:  userID,
+		ActorIP: a.Actor.IP,
+		Action:  action,
+		Before:  beforeJSON,
+		After:   afterJSON,
+	}
+	if a.Actor.UserID > 0 {
+		actorID := a.Actor.UserID
+		event.ActorID = &actorID
+	}
+
+	if err := tx.QueryRowxContext(
+		ctx,
+		insertAuditEventQuery,
+		event.UserID,
+		event.ActorID,
+		event.ActorIP,
+		event.Action,
+		string(event.Before),
+		string(event.After),
+		time.Now(),
+	).Scan(&event.ID, &event.CreatedAt); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, insertAuditOutboxQuery, event.ID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// publish passes the committed event to the publisher. The event is already
+// stored, and stays in the outbox if publishing fails, so failures are
+// only logged.
+func (a Auditor) publish(event *AuditEvent) {
+	if a.Publisher == nil || event == nil {
+		return
+	}
+
+	if err := a.Publisher.PublishAudit(*event); err != nil {
+		log.Printf("Publishing audit event %d failed, it's left in the outbox: %v\n", event.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := a.db.ExecContext(ctx, deleteAuditOutboxQuery, event.ID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+	}
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuthorizationCode is the structure which holds one OAuth2 authorization
+// code from the database, with the parameters of the request it was issued
+// for. Only the SHA-256 hash of the code is stored.
+type AuthorizationCode struct {
+	PlainText     string    `json:"code" db:"-"`
+	Hash          []byte    `json:"-" db:"code_hash"`
+	UserID        int       `json:"user_id" db:"user_id"`
+	ClientID      string    `json:"client_id" db:"client_id"`
+	RedirectURI   string    `json:"redirect_uri" db:"redirect_uri"`
+	Scope         string    `json:"scope" db:"scope"`
+	Nonce         string    `json:"nonce" db:"nonce"`
+	CodeChallenge string    `json:"code_challenge" db:"code_challenge"`
+	AuthTime      time.Time `json:"auth_time" db:"auth_time"`
+	Expiry        time.Time `json:"expiry" db:"expiry"`
+}
+
+// AuthorizationCodeRepository is the interface of the storage which holds
+// authorization codes.
+type AuthorizationCodeRepository interface {
+	// Insert puts new authorization code to the storage.
+	Insert(code AuthorizationCode) error
+	// Consume deletes not expired code by its plain text and returns it,
+	// so every code can be exchanged only once.
+	Consume(plainText string) (*AuthorizationCode, error)
+}
+
+// postgresAuthorizationCodeRepository is the AuthorizationCodeRepository backed by Postgres.
+type postgresAuthorizationCodeRepository struct {
+	db *sqlx.DB
+}
+
+// NewAuthorizationCodeRepository creates AuthorizationCodeRepository which
+// stores authorization codes in Postgres.
+func NewAuthorizationCodeRepository(db *sqlx.DB) AuthorizationCodeRepository {
+	return &postgresAuthorizationCodeRepository{db: db}
+}
+
+// GenerateAuthorizationCode fills code with a new random plain text and
+// its hash, and sets it to expire after ttl. The code is not saved to the database.
+func GenerateAuthorizationCode(code AuthorizationCode, ttl time.Duration) (*AuthorizationCode, error) {
+	plainText, err := randomPlainText()
+	if err != nil {
+		return nil, err
+	}
+
+	code.PlainText = plainText
+	code.Hash = hashToken(plainText)
+	code.Expiry = time.Now().Add(ttl)
+
+	return &code, nil
+}
+
+// Insert puts new authorization code to the database.
+func (r *postgresAuthorizationCodeRepository) Insert(code AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	args := []any{
+		code.Hash,
+		code.UserID,
+		code.ClientID,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.AuthTime,
+		code.Expiry,
+		time.Now(),
+	}
+	if _, err := r.db.ExecContext(ctx, insertAuthorizationCodeQuery, args...); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Consume deletes not expired code by its plain text and returns it.
+func (r *postgresAuthorizationCodeRepository) Consume(plainText string) (*AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var code AuthorizationCode
+	if err := r.db.GetContext(
+		ctx,
+		&code,
+		consumeAuthorizationCodeQuery,
+		hashToken(plainText),
+		time.Now(),
+	); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return &code, nil
+}
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Names of the supported password hashing algorithms.
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+var (
+	// ErrUnknownHash is returned when a stored hash was made with an unsupported algorithm.
+	ErrUnknownHash = errors.New("unknown password hash format")
+	// ErrMalformedHash is returned when a stored hash can't be decoded.
+	ErrMalformedHash = errors.New("malformed password hash")
+)
+
+// PasswordHasher hashes passwords with one algorithm and set of parameters,
+// and checks passwords against hashes made with any supported algorithm.
+type PasswordHasher interface {
+	// Hash gets encoded hash of the password, which holds the algorithm and its parameters.
+	Hash(password string) (string, error)
+	// Matches compares the password with the hash.
+	Matches(hash, password string) (bool, error)
+	// NeedsRehash returns true if the hash was made with another algorithm
+	// or parameters than the ones the hasher uses now.
+	NeedsRehash(hash string) bool
+}
+
+// NewPasswordHasher creates PasswordHasher for the named algorithm.
+func NewPasswordHasher(algorithm string, bcryptCost int, argon Argon2idHasher) (PasswordHasher, error) {
+	switch algorithm {
+	case AlgorithmArgon2id:
+		return &argon, nil
+	case AlgorithmBcrypt:
+		return &BcryptHasher{Cost: bcryptCost}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %q", algorithm)
+	}
+}
+
+// BcryptHasher hashes passwords with bcrypt.
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash gets bcrypt hash of the password.
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Matches compares the password with the hash.
+func (h *BcryptHasher) Matches(hash, password string) (bool, error) {
+	return passwordMatches(hash, password)
+}
+
+// NeedsRehash returns true if the hash is not bcrypt or has another cost.
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id.
+type Argon2idHasher struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Hash gets argon2id hash of the password in the PHC string format,
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.Memory,
+		h.Iterations,
+		h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Matches compares the password with the hash.
+func (h *Argon2idHasher) Matches(hash, password string) (bool, error) {
+	return passwordMatches(hash, password)
+}
+
+// NeedsRehash returns true if the hash is not argon2id or has other parameters.
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength ||
+		uint32(len(key)) != h.KeyLength
+}
+
+// passwordMatches compares the password with the hash made by any supported
+// algorithm, which is told by the hash prefix.
+func passwordMatches(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+
+		otherKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+		return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				// invalid password
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+// decodeArgon2id gets parameters, salt and key from argon2id hash.
+func decodeArgon2id(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return nil, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrMalformedHash
+	}
+
+	var params Argon2idHasher
+	if _, err := fmt.Sscanf(
+		parts[3],
+		"m=%d,t=%d,p=%d",
+		&params.Memory,
+		&params.Iterations,
+		&params.Parallelism,
+	); err != nil {
+		return nil, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrMalformedHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrMalformedHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return &params, salt, key, nil
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LoginAttempt is the structure which holds failed login attempts counted
+// for one subject, such as email or client IP, within one scope.
+type LoginAttempt struct {
+	Scope         string     `json:"scope" db:"scope"`
+	Subject       string     `json:"subject" db:"subject"`
+	Failures      int        `json:"failures" db:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at" db:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+// IsLocked returns true if the subject is locked out at the moment t.
+func (a *LoginAttempt) IsLocked(t time.Time) bool {
+	return a.LockedUntil != nil && a.LockedUntil.After(t)
+}
+
+// LoginAttemptRepository is the interface of the storage which holds failed login attempts.
+type LoginAttemptRepository interface {
+	// Get returns failed login attempts of the subject in the scope.
+	Get(scope, subject string) (*LoginAttempt, error)
+	// RecordFailure counts one more failed attempt of the subject in the scope.
+	RecordFailure(scope, subject string) (*LoginAttempt, error)
+	// Lock locks the subject in the scope out until the given time.
+	Lock(scope, subject string, until time.Time) error
+	// Reset forgets failed attempts and lockout of the subject in the scope.
+	Reset(scope, subject string) error
+}
+
+// postgresLoginAttemptRepository is the LoginAttemptRepository backed by Postgres.
+type postgresLoginAttemptRepository struct {
+	db *sqlx.DB
+}
+
+// NewLoginAttemptRepository creates LoginAttemptRepository which stores failed
+// login attempts in Postgres.
+func NewLoginAttemptRepository(db *sqlx.DB) LoginAttemptRepository {
+	return &postgresLoginAttemptRepository{db: db}
+}
+
+// Get returns failed login attempts of the subject in the scope.
+func (r *postgresLoginAttemptRepository) Get(scope, subject string) (*LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var attempt LoginAttempt
+	if err := r.db.GetContext(ctx, &attempt, getLoginAttemptQuery, scope, subject); err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// RecordFailure counts one more failed attempt of the subject in the scope
+// and returns the updated counter.
+func (r *postgresLoginAttemptRepository) RecordFailure(scope, subject string) (*LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var attempt LoginAttempt
+	if err := r.db.GetContext(
+		ctx,
+		&attempt,
+		recordLoginFailureQuery,
+		scope,
+		subject,
+		time.Now(),
+	); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// Lock locks the subject in the scope out until the given time.
+func (r *postgresLoginAttemptRepository) Lock(scope, subject string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, lockLoginAttemptQuery, until, scope, subject); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Reset forgets failed attempts and lockout of the subject in the scope.
+func (r *postgresLoginAttemptRepository) Reset(scope, subject string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, deleteLoginAttemptQuery, scope, subject); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,770 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errDuplicateEmail is what the in-memory users have for the unique
+// constraint on emails.
+var errDuplicateEmail = errors.New("a user with this email address already exists")
+
+// NewInMemory creates Models which keep everything in memory, with
+// passwords of new users hashed by hasher. They are meant for tests of the
+// code using the repositories: nothing is persisted, and no audit trail is
+// recorded. Missing records are reported with sql.ErrNoRows, as in Postgres.
+// The admin and user roles exist with the permissions the migrations give
+// them.
+func NewInMemory(hasher PasswordHasher) Models {
+	store := &memoryStore{
+		users:     map[int]*User{},
+		tokens:    map[string]*Token{},
+		attempts:  map[[2]string]*LoginAttempt{},
+		oneTime:   map[string]*OneTimeToken{},
+		userRoles: map[int][]string{},
+		totps:     map[int]*TOTP{},
+		recovery:  map[int]map[string]bool{},
+		codes:     map[string]*AuthorizationCode{},
+		apiKeys:   map[int]*APIKey{},
+		roles: []*Role{
+			{
+				ID:          1,
+				Name:        RoleAdmin,
+				Description: "Full access to every action",
+				Permissions: []string{
+					PermissionUsersRead, PermissionUsersWrite, PermissionUsersDelete, PermissionRolesWrite,
+					"logs:read", "logs:write", "logs:admin", "mail:send",
+				},
+			},
+			{
+				ID:          2,
+				Name:        RoleUser,
+				Description: "Default role of registered users",
+				Permissions: []string{"logs:write", "mail:send"},
+			},
+		},
+	}
+
+	return Models{
+		User:         &memoryUserRepository{store: store, hasher: hasher},
+		Token:        &memoryTokenRepository{store},
+		LoginAttempt: &memoryLoginAttemptRepository{store},
+		OneTimeToken: &memoryOneTimeTokenRepository{store},
+		Role:         &memoryRoleRepository{store},
+		TwoFactor:    &memoryTwoFactorRepository{store},
+		Audit:        &memoryAuditRepository{},
+		AuthCode:     &memoryAuthorizationCodeRepository{store},
+		APIKey:       &memoryAPIKeyRepository{store},
+
+		hasher: hasher,
+	}
+}
+
+// memoryStore holds the records of all in-memory repositories. Records are
+// copied in and out, so callers never share them.
+type memoryStore struct {
+	mu sync.Mutex
+
+	users      map[int]*User
+	lastUserID int
+	tokens     map[string]*Token
+	attempts   map[[2]string]*LoginAttempt
+	oneTime    map[string]*OneTimeToken
+	roles      []*Role
+	userRoles  map[int][]string
+	totps      map[int]*TOTP
+	recovery   map[int]map[string]bool
+	codes      map[string]*AuthorizationCode
+	apiKeys    map[int]*APIKey
+	lastKeyID  int
+}
+
+// memoryUserRepository is the UserRepository kept in memory.
+type memoryUserRepository struct {
+	store  *memoryStore
+	hasher PasswordHasher
+}
+
+// GetAll returns all users.
+func (r *memoryUserRepository) GetAll() ([]*User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	users := make([]*User, 0, len(r.store.users))
+	for _, user := range r.store.users {
+		u := *user
+		users = append(users, &u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].LastName < users[j].LastName
+	})
+
+	return users, nil
+}
+
+// GetPage returns one page of users ordered by last name, with
+// the total number of users.
+func (r *memoryUserRepository) GetPage(limit, offset int) ([]*User, int, error) {
+	users, _ := r.GetAll()
+	total := len(users)
+
+	if offset > total {
+		offset = total
+	}
+	if offset+limit < total {
+		users = users[offset : offset+limit]
+	} else {
+		users = users[offset:]
+	}
+
+	return users, total, nil
+}
+
+// GetByEmail returns one user by Email.
+func (r *memoryUserRepository) GetByEmail(email string) (*User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, user := range r.store.users {
+		if user.Email == email {
+			u := *user
+			return &u, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// GetOne returns one user by ID.
+func (r *memoryUserRepository) GetOne(id int) (*User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, ok := r.store.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	u := *user
+	return &u, nil
+}
+
+// Update updates one user, using the information stored in user.
+func (r *memoryUserRepository) Update(user User) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	before, ok := r.store.users[user.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	user.Password = before.Password
+	user.CreatedAt = before.CreatedAt
+	user.UpdatedAt = time.Now()
+	r.store.users[user.ID] = &user
+
+	return nil
+}
+
+// Delete deletes one user by ID, with everything the user owns.
+func (r *memoryUserRepository) Delete(id int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.users[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(r.store.users, id)
+	delete(r.store.userRoles, id)
+	delete(r.store.totps, id)
+	delete(r.store.recovery, id)
+
+	return nil
+}
+
+// Insert puts new user to the storage and returns id of inserted user.
+func (r *memoryUserRepository) Insert(user User) (int, error) {
+	hashedPassword, err := r.hasher.Hash(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, existing := range r.store.users {
+		if existing.Email == user.Email {
+			return 0, errDuplicateEmail
+		}
+	}
+
+	r.store.lastUserID++
+	user.ID = r.store.lastUserID
+	user.Password = hashedPassword
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	r.store.users[user.ID] = &user
+
+	return user.ID, nil
+}
+
+// ResetPassword changes password of the user with the given ID.
+func (r *memoryUserRepository) ResetPassword(id int, password string) error {
+	hashedPassword, err := r.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, ok := r.store.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	user.Password = hashedPassword
+
+	return nil
+}
+
+// Rehash replaces the password hash of the user with a new hash of the same password.
+func (r *memoryUserRepository) Rehash(id int, hash string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if user, ok := r.store.users[id]; ok {
+		user.Password = hash
+	}
+
+	return nil
+}
+
+// memoryTokenRepository is the TokenRepository kept in memory.
+type memoryTokenRepository struct {
+	store *memoryStore
+}
+
+// Insert puts new refresh token to the storage.
+func (r *memoryTokenRepository) Insert(token Token) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	token.PlainText = ""
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = token.CreatedAt
+	r.store.tokens[string(token.Hash)] = &token
+
+	return nil
+}
+
+// GetByPlainText returns one not expired refresh token by its plain text.
+func (r *memoryTokenRepository) GetByPlainText(plainText string) (*Token, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	token, ok := r.store.tokens[string(hashToken(plainText))]
+	if !ok || !token.Expiry.After(time.Now()) {
+		return nil, sql.ErrNoRows
+	}
+
+	t := *token
+	return &t, nil
+}
+
+// Consume deletes one not expired refresh token by its plain text and returns it.
+func (r *memoryTokenRepository) Consume(plainText string) (*Token, error) {
+	token, err := r.GetByPlainText(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	// another call may have consumed it meanwhile
+	if _, ok := r.store.tokens[string(token.Hash)]; !ok {
+		return nil, sql.ErrNoRows
+	}
+	delete(r.store.tokens, string(token.Hash))
+
+	return token, nil
+}
+
+// DeleteByPlainText deletes one refresh token by its plain text.
+func (r *memoryTokenRepository) DeleteByPlainText(plainText string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.store.tokens, string(hashToken(plainText)))
+
+	return nil
+}
+
+// DeleteAllForUser deletes every refresh token issued to the user with the given id.
+func (r *memoryTokenRepository) DeleteAllForUser(userID int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for hash, token := range r.store.tokens {
+		if token.UserID == userID {
+			delete(r.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+// memoryLoginAttemptRepository is the LoginAttemptRepository kept in memory.
+type memoryLoginAttemptRepository struct {
+	store *memoryStore
+}
+
+// Get returns failed login attempts of the subject in the scope.
+func (r *memoryLoginAttemptRepository) Get(scope, subject string) (*LoginAttempt, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	attempt, ok := r.store.attempts[[2]string{scope, subject}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	a := *attempt
+	return &a, nil
+}
+
+// RecordFailure counts one more failed attempt of the subject in the scope.
+func (r *memoryLoginAttemptRepository) RecordFailure(scope, subject string) (*LoginAttempt, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	key := [2]string{scope, subject}
+	attempt, ok := r.store.attempts[key]
+	if !ok {
+		attempt = &LoginAttempt{Scope: scope, Subject: subject}
+		r.store.attempts[key] = attempt
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = time.Now()
+
+	a := *attempt
+	return &a, nil
+}
+
+// Lock locks the subject in the scope out until the given time.
+func (r *memoryLoginAttemptRepository) Lock(scope, subject string, until time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if attempt, ok := r.store.attempts[[2]string{scope, subject}]; ok {
+		attempt.LockedUntil = &until
+	}
+
+	return nil
+}
+
+// Reset forgets failed attempts and lockout of the subject in the scope.
+func (r *memoryLoginAttemptRepository) Reset(scope, subject string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.store.attempts, [2]string{scope, subject})
+
+	return nil
+}
+
+// memoryOneTimeTokenRepository is the OneTimeTokenRepository kept in memory.
+type memoryOneTimeTokenRepository struct {
+	store *memoryStore
+}
+
+// Insert puts new single-use token to the storage.
+func (r *memoryOneTimeTokenRepository) Insert(token OneTimeToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	token.PlainText = ""
+	token.CreatedAt = time.Now()
+	r.store.oneTime[string(token.Hash)] = &token
+
+	return nil
+}
+
+// Get returns not expired token of the scope by its plain text, without using it up.
+func (r *memoryOneTimeTokenRepository) Get(scope, plainText string) (*OneTimeToken, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	token, ok := r.store.oneTime[string(hashToken(plainText))]
+	if !ok || token.Scope != scope || !token.Expiry.After(time.Now()) {
+		return nil, sql.ErrNoRows
+	}
+
+	t := *token
+	return &t, nil
+}
+
+// Consume deletes not expired token of the scope by its plain text and returns it.
+func (r *memoryOneTimeTokenRepository) Consume(scope, plainText string) (*OneTimeToken, error) {
+	token, err := r.Get(scope, plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.oneTime[string(token.Hash)]; !ok {
+		return nil, sql.ErrNoRows
+	}
+	delete(r.store.oneTime, string(token.Hash))
+
+	return token, nil
+}
+
+// DeleteAllForUser deletes every token of the scope issued to the user with the given id.
+func (r *memoryOneTimeTokenRepository) DeleteAllForUser(scope string, userID int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for hash, token := range r.store.oneTime {
+		if token.Scope == scope && token.UserID == userID {
+			delete(r.store.oneTime, hash)
+		}
+	}
+
+	return nil
+}
+
+// memoryRoleRepository is the RoleRepository kept in memory.
+type memoryRoleRepository struct {
+	store *memoryStore
+}
+
+// GetAll returns all roles with their permissions.
+func (r *memoryRoleRepository) GetAll() ([]*Role, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	roles := make([]*Role, 0, len(r.store.roles))
+	for _, role := range r.store.roles {
+		rl := *role
+		rl.Permissions = append([]string{}, role.Permissions...)
+		roles = append(roles, &rl)
+	}
+
+	return roles, nil
+}
+
+// GetForUser returns names of the roles assigned to the user with the given id.
+func (r *memoryRoleRepository) GetForUser(userID int) ([]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return append([]string{}, r.store.userRoles[userID]...), nil
+}
+
+// GetPermissionsForUser returns names of all permissions granted to the
+// user with the given id through the user's roles, ordered by name.
+func (r *memoryRoleRepository) GetPermissionsForUser(userID int) ([]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	granted := map[string]bool{}
+	for _, name := range r.store.userRoles[userID] {
+		for _, role := range r.store.roles {
+			if role.Name != name {
+				continue
+			}
+			for _, permission := range role.Permissions {
+				granted[permission] = true
+			}
+		}
+	}
+
+	permissions := make([]string, 0, len(granted))
+	for permission := range granted {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+
+	return permissions, nil
+}
+
+// SetForUser replaces roles of the user with the given id with the named ones.
+// Nothing changes and ErrUnknownRole is returned if any of the roles doesn't exist.
+func (r *memoryRoleRepository) SetForUser(userID int, roles []string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	names := []string{}
+	for _, name := range roles {
+		found := false
+		for _, role := range r.store.roles {
+			found = found || role.Name == name
+		}
+		if !found {
+			return ErrUnknownRole
+		}
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	r.store.userRoles[userID] = names
+
+	return nil
+}
+
+// memoryTwoFactorRepository is the TwoFactorRepository kept in memory.
+// Secrets are kept as they are, since they never leave the process.
+type memoryTwoFactorRepository struct {
+	store *memoryStore
+}
+
+// GetTOTP returns TOTP of the user with the given id.
+func (r *memoryTwoFactorRepository) GetTOTP(userID int) (*TOTP, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	totp, ok := r.store.totps[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	t := *totp
+	return &t, nil
+}
+
+// SaveTOTP replaces TOTP of the user with a new not confirmed one.
+func (r *memoryTwoFactorRepository) SaveTOTP(userID int, secret string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.totps[userID] = &TOTP{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+
+	return nil
+}
+
+// ConfirmTOTP marks TOTP of the user as confirmed, so it is required on login.
+func (r *memoryTwoFactorRepository) ConfirmTOTP(userID int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	totp, ok := r.store.totps[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	totp.Confirmed = true
+	totp.ConfirmedAt = &now
+
+	return nil
+}
+
+// UseStep records the time step of an accepted code. It returns false if
+// the same or a later step was already used.
+func (r *memoryTwoFactorRepository) UseStep(userID int, step int64) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	totp, ok := r.store.totps[userID]
+	if !ok || totp.LastUsedStep >= step {
+		return false, nil
+	}
+	totp.LastUsedStep = step
+
+	return true, nil
+}
+
+// DeleteTOTP deletes TOTP and recovery codes of the user.
+func (r *memoryTwoFactorRepository) DeleteTOTP(userID int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.store.totps, userID)
+	delete(r.store.recovery, userID)
+
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces recovery codes of the user.
+func (r *memoryTwoFactorRepository) ReplaceRecoveryCodes(userID int, codes []string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	unused := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		unused[normalizeRecoveryCode(code)] = true
+	}
+	r.store.recovery[userID] = unused
+
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code of the user as used. It returns
+// false if there is no such unused code.
+func (r *memoryTwoFactorRepository) UseRecoveryCode(userID int, code string) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	code = normalizeRecoveryCode(code)
+	if !r.store.recovery[userID][code] {
+		return false, nil
+	}
+	r.store.recovery[userID][code] = false
+
+	return true, nil
+}
+
+// memoryAuditRepository is the AuditRepository of Models which record no
+// audit trail, so it's always empty.
+type memoryAuditRepository struct{}
+
+// GetPageForUser returns no events.
+func (r *memoryAuditRepository) GetPageForUser(_, _, _ int) ([]*AuditEvent, int, error) {
+	return []*AuditEvent{}, 0, nil
+}
+
+// GetUnpublished returns no events.
+func (r *memoryAuditRepository) GetUnpublished(_ time.Time, _ int) ([]*AuditEvent, error) {
+	return []*AuditEvent{}, nil
+}
+
+// MarkPublished does nothing.
+func (r *memoryAuditRepository) MarkPublished(_ int64) error {
+	return nil
+}
+
+// memoryAuthorizationCodeRepository is the AuthorizationCodeRepository kept in memory.
+type memoryAuthorizationCodeRepository struct {
+	store *memoryStore
+}
+
+// Insert puts new authorization code to the storage.
+func (r *memoryAuthorizationCodeRepository) Insert(code AuthorizationCode) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	code.PlainText = ""
+	r.store.codes[string(code.Hash)] = &code
+
+	return nil
+}
+
+// Consume deletes not expired code by its plain text and returns it.
+func (r *memoryAuthorizationCodeRepository) Consume(plainText string) (*AuthorizationCode, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	hash := string(hashToken(plainText))
+	code, ok := r.store.codes[hash]
+	if !ok || !code.Expiry.After(time.Now()) {
+		return nil, sql.ErrNoRows
+	}
+	delete(r.store.codes, hash)
+
+	return code, nil
+}
+
+// memoryAPIKeyRepository is the APIKeyRepository kept in memory.
+type memoryAPIKeyRepository struct {
+	store *memoryStore
+}
+
+// Insert puts new API key to the storage and returns its id.
+func (r *memoryAPIKeyRepository) Insert(key APIKey) (int, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.lastKeyID++
+	key.ID = r.store.lastKeyID
+	key.PlainText = ""
+	key.ScopeList = strings.Join(key.Scopes, " ")
+	key.CreatedAt = time.Now()
+	r.store.apiKeys[key.ID] = &key
+
+	return key.ID, nil
+}
+
+// GetAllForUser returns all API keys of the user with the given id.
+func (r *memoryAPIKeyRepository) GetAllForUser(userID int) ([]*APIKey, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	keys := []*APIKey{}
+	for _, key := range r.store.apiKeys {
+		if key.UserID == userID {
+			k := *key
+			keys = append(keys, &k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys, nil
+}
+
+// GetByPlainText returns one not expired API key by its plain text.
+func (r *memoryAPIKeyRepository) GetByPlainText(plainText string) (*APIKey, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	hash := string(hashToken(plainText))
+	for _, key := range r.store.apiKeys {
+		if string(key.Hash) != hash {
+			continue
+		}
+		if key.Expiry != nil && !key.Expiry.After(time.Now()) {
+			break
+		}
+		k := *key
+		return &k, nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// Touch records the time the API key was last used.
+func (r *memoryAPIKeyRepository) Touch(id int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if key, ok := r.store.apiKeys[id]; ok {
+		now := time.Now()
+		key.LastUsedAt = &now
+	}
+
+	return nil
+}
+
+// Delete revokes API key with the given id of the user with the given id.
+func (r *memoryAPIKeyRepository) Delete(id, userID int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	key, ok := r.store.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(r.store.apiKeys, id)
+
+	return nil
+}
+
+// containsString returns true if the list has the value.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestResetPassword(t *testing.T) {
+	models := NewInMemory(&BcryptHasher{Cost: 4})
+
+	id, err := models.User.Insert(User{Email: "ann@example.com", Password: "correct horse battery", Active: true})
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+
+	if err := models.User.ResetPassword(id, "new correct horse battery"); err != nil {
+		t.Fatalf("resetting password: %v", err)
+	}
+	user, err := models.User.GetOne(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := user.PasswordMatches("new correct horse battery"); err != nil || !ok {
+		t.Errorf("new password doesn't match: %v", err)
+	}
+
+	if err := models.User.ResetPassword(id+1, "new correct horse battery"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ResetPassword of unknown id error = %v, want %v", err, sql.ErrNoRows)
+	}
+}
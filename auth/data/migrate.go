@@ -0,0 +1,285 @@
+package data
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// migrationTimeout limits waiting for the lock and applying migrations,
+	// which may take much longer than the usual queries.
+	migrationTimeout = time.Minute * 5
+
+	// migrationLockID is the key of the Postgres advisory lock which is held
+	// while migrating, so replicas starting together don't race.
+	migrationLockID int64 = 7_321_554_108
+)
+
+// migrationFiles holds the versioned migrations, named NNNN_name.up.sql
+// and NNNN_name.down.sql.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one version of the database schema.
+type Migration struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"-"`
+	Down    string `json:"-"`
+}
+
+// MigrationStatus tells whether and when Migration was applied.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// Migrator applies the embedded migrations to the database. Each migration
+// runs in its own transaction, and the versions applied are tracked in the
+// schema_migrations table.
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+// NewMigrator creates Migrator of the embedded migrations.
+func NewMigrator(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// loadMigrations reads the migrations in dir ordered by version.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		base, direction, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must end with .up.sql or .down.sql", fileName)
+		}
+
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(number, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", fileName)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies all migrations which are not applied yet, and returns them.
+func (m *Migrator) Up() ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(func(ctx context.Context, conn *sqlx.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			if err := runMigration(ctx, conn, migration.Up, insertSchemaMigrationQuery, migration.Version, migration.Name, time.Now()); err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("Applied migration %d %s\n", migration.Version, migration.Name)
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns them.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.withLock(func(ctx context.Context, conn *sqlx.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d %s can't be reverted", migration.Version, migration.Name)
+			}
+
+			if err := runMigration(ctx, conn, migration.Down, deleteSchemaMigrationQuery, migration.Version); err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("Reverted migration %d %s\n", migration.Version, migration.Name)
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status returns all migrations with the time they were applied at, if they were.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var status []MigrationStatus
+
+	err := m.withLock(func(ctx context.Context, conn *sqlx.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			s := MigrationStatus{Migration: migration}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				s.AppliedAt = &appliedAt
+			}
+			status = append(status, s)
+		}
+
+		return nil
+	})
+
+	return status, err
+}
+
+// withLock runs fn on one connection holding the advisory lock of migrations.
+// The lock belongs to the session, so all the work must be done on conn.
+func (m *Migrator) withLock(fn func(ctx context.Context, conn *sqlx.Conn) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, advisoryLockQuery, migrationLockID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+	defer func() {
+		// unlock even if ctx is done, or the lock stays with the pooled connection
+		unlockCtx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		defer cancel()
+
+		if _, err := conn.ExecContext(unlockCtx, advisoryUnlockQuery, migrationLockID); err != nil {
+			log.Printf("Query failed: %v\n", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, createSchemaMigrationsQuery); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+// appliedVersions returns the versions of applied migrations with the time
+// they were applied at.
+func appliedVersions(ctx context.Context, conn *sqlx.Conn) (map[int64]time.Time, error) {
+	var rows []struct {
+		Version   int64     `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := conn.SelectContext(ctx, &rows, getAppliedMigrationsQuery); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	versions := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		versions[row.Version] = row.AppliedAt
+	}
+
+	return versions, nil
+}
+
+// runMigration executes the migration script and the bookkeeping query with
+// its args in one transaction. The script is run without arguments, so it
+// may hold several statements.
+func runMigration(ctx context.Context, conn *sqlx.Conn, script, query string, args ...any) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,50 @@
+--
+-- Name: user_id_seq; Type: SEQUENCE; Schema: public
+--
+
+CREATE SEQUENCE IF NOT EXISTS public.user_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+--
+-- Name: users; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.users (
+    id integer DEFAULT nextval('public.user_id_seq'::regclass) NOT NULL,
+    email character varying(40) NOT NULL,
+    first_name character varying(255),
+    last_name character varying(255),
+    password character varying(255) NOT NULL,
+    active bool DEFAULT FALSE,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    CONSTRAINT users_pkey PRIMARY KEY (id),
+    CONSTRAINT users_email_key UNIQUE (email)
+);
+
+ALTER SEQUENCE public.user_id_seq OWNED BY public.users.id;
+
+INSERT INTO public.users(
+    "email",
+    "first_name",
+    "last_name",
+    "password",
+    "active",
+    "created_at",
+    "updated_at"
+)
+VALUES
+(
+    E'admin@example.com',
+    E'Admin',
+    E'User',
+    E'$2a$12$txp8To/XkvyEfdM4WLA3Ze/wM5rOH9SPQGgmfsH9kDQ2ZqWDKaWCa',
+    E'TRUE',
+    E'2023-03-10 00:00:00',
+    E'2023-03-10 00:00:00'
+)
+ON CONFLICT (email) DO NOTHING;
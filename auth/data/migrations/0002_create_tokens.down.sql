@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.one_time_tokens;
+
+DROP TABLE IF EXISTS public.refresh_tokens;
@@ -0,0 +1,29 @@
+--
+-- Name: refresh_tokens; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    token_hash bytea NOT NULL UNIQUE,
+    expiry timestamp without time zone NOT NULL,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON public.refresh_tokens (user_id);
+
+--
+-- Name: one_time_tokens; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.one_time_tokens (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    scope character varying(20) NOT NULL,
+    token_hash bytea NOT NULL UNIQUE,
+    expiry timestamp without time zone NOT NULL,
+    created_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS one_time_tokens_user_id_scope_idx ON public.one_time_tokens (user_id, scope);
@@ -0,0 +1,12 @@
+--
+-- Name: login_attempts; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.login_attempts (
+    scope character varying(10) NOT NULL,
+    subject character varying(255) NOT NULL,
+    failures integer DEFAULT 0 NOT NULL,
+    last_failure_at timestamp without time zone NOT NULL,
+    locked_until timestamp without time zone,
+    PRIMARY KEY (scope, subject)
+);
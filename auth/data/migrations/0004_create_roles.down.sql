@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS public.user_roles;
+
+DROP TABLE IF EXISTS public.role_permissions;
+
+DROP TABLE IF EXISTS public.permissions;
+
+DROP TABLE IF EXISTS public.roles;
@@ -0,0 +1,76 @@
+--
+-- Name: roles; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.roles (
+    id serial PRIMARY KEY,
+    name character varying(50) NOT NULL UNIQUE,
+    description character varying(255) DEFAULT '' NOT NULL,
+    created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+--
+-- Name: permissions; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.permissions (
+    id serial PRIMARY KEY,
+    name character varying(50) NOT NULL UNIQUE
+);
+
+--
+-- Name: role_permissions; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.role_permissions (
+    role_id integer NOT NULL REFERENCES public.roles(id) ON DELETE CASCADE,
+    permission_id integer NOT NULL REFERENCES public.permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+--
+-- Name: user_roles; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.user_roles (
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    role_id integer NOT NULL REFERENCES public.roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO public.roles(name, description)
+VALUES
+    ('admin', 'Full access to every action'),
+    ('user', 'Default role of registered users')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.permissions(name)
+VALUES
+    ('users:read'),
+    ('users:write'),
+    ('users:delete'),
+    ('roles:write'),
+    ('logs:read'),
+    ('logs:write'),
+    ('mail:send')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM public.roles r, public.permissions p
+WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO public.role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM public.roles r, public.permissions p
+WHERE r.name = 'user'
+    AND p.name IN ('logs:write', 'mail:send')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO public.user_roles(user_id, role_id)
+SELECT u.id, r.id
+FROM public.users u, public.roles r
+WHERE u.email = 'admin@example.com'
+    AND r.name IN ('admin', 'user')
+ON CONFLICT DO NOTHING;
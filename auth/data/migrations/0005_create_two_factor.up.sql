@@ -0,0 +1,26 @@
+--
+-- Name: user_totp; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.user_totp (
+    user_id integer PRIMARY KEY REFERENCES public.users(id) ON DELETE CASCADE,
+    secret bytea NOT NULL,
+    confirmed bool DEFAULT FALSE NOT NULL,
+    last_used_step bigint DEFAULT 0 NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    confirmed_at timestamp without time zone
+);
+
+--
+-- Name: recovery_codes; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.recovery_codes (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    code_hash bytea NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON public.recovery_codes (user_id);
@@ -0,0 +1,22 @@
+--
+-- Name: user_audit; Type: TABLE; Schema: public
+--
+-- Append-only history of account changes. It outlives deleted users,
+-- so user_id is not a foreign key.
+--
+
+CREATE TABLE IF NOT EXISTS public.user_audit (
+    id bigserial PRIMARY KEY,
+    user_id integer NOT NULL,
+    actor_id integer,
+    actor_ip character varying(64) DEFAULT '' NOT NULL,
+    action character varying(64) NOT NULL,
+    before jsonb,
+    after jsonb,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON public.user_audit (user_id, id);
+
+CREATE OR REPLACE RULE user_audit_no_update AS ON UPDATE TO public.user_audit DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE user_audit_no_delete AS ON DELETE TO public.user_audit DO INSTEAD NOTHING;
@@ -0,0 +1,16 @@
+--
+-- Name: authorization_codes; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.authorization_codes (
+    code_hash bytea PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    client_id character varying(255) NOT NULL,
+    redirect_uri text NOT NULL,
+    scope text NOT NULL,
+    nonce text DEFAULT '' NOT NULL,
+    code_challenge character varying(128) NOT NULL,
+    auth_time timestamp without time zone NOT NULL,
+    expiry timestamp without time zone NOT NULL,
+    created_at timestamp without time zone NOT NULL
+);
@@ -0,0 +1,17 @@
+--
+-- Name: api_keys; Type: TABLE; Schema: public
+--
+
+CREATE TABLE IF NOT EXISTS public.api_keys (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    name character varying(255) NOT NULL,
+    prefix character varying(16) NOT NULL,
+    key_hash bytea NOT NULL UNIQUE,
+    scopes text DEFAULT '' NOT NULL,
+    expiry timestamp without time zone,
+    last_used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON public.api_keys (user_id);
@@ -0,0 +1 @@
+DELETE FROM public.permissions WHERE name = 'logs:admin';
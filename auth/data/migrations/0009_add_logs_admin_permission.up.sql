@@ -0,0 +1,11 @@
+INSERT INTO public.permissions(name)
+VALUES
+    ('logs:admin')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM public.roles r, public.permissions p
+WHERE r.name = 'admin'
+    AND p.name = 'logs:admin'
+ON CONFLICT DO NOTHING;
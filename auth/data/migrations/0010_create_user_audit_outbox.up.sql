@@ -0,0 +1,11 @@
+--
+-- Name: user_audit_outbox; Type: TABLE; Schema: public
+--
+-- Audit events not yet published to RabbitMQ. Events are added in the
+-- transaction which records them, and removed once published, so events
+-- recorded while RabbitMQ is down are published later.
+--
+
+CREATE TABLE IF NOT EXISTS public.user_audit_outbox (
+    event_id bigint PRIMARY KEY REFERENCES public.user_audit(id)
+);
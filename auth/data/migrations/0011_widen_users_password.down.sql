@@ -0,0 +1,5 @@
+--
+-- Fails while any argon2id hashes are stored, they don't fit bcrypt's size.
+--
+
+ALTER TABLE public.users ALTER COLUMN password TYPE character varying(60);
@@ -0,0 +1,6 @@
+--
+-- Databases created before the migrations have password varchar(60), which
+-- fits bcrypt hashes only. Argon2id hashes are about 97 characters long.
+--
+
+ALTER TABLE public.users ALTER COLUMN password TYPE character varying(255);
@@ -2,34 +2,72 @@ package data
 
 import (
 	"context"
-	"errors"
+	"database/sql"
 	"log"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	dbTimeout = time.Second * 3
 )
 
-var db *sqlx.DB
-
 // Models is the type for this package. Note that any model that is included as a member
 // in this type is available to us throughout the application, anywhere that the
 // app variable is used, provided that the model is also added in teh New function
 type Models struct {
-	User User
+	User         UserRepository
+	Token        TokenRepository
+	LoginAttempt LoginAttemptRepository
+	OneTimeToken OneTimeTokenRepository
+	Role         RoleRepository
+	TwoFactor    TwoFactorRepository
+	Audit        AuditRepository
+	AuthCode     AuthorizationCodeRepository
+	APIKey       APIKeyRepository
+
+	db        *sqlx.DB
+	hasher    PasswordHasher
+	box       *SecretBox
+	publisher AuditPublisher
 }
 
 // New is the function used to create an instance of the data package. It returns the type
-// Models, which embeds all the types we want to be available to our application.
-func New(dbPool *sqlx.DB) Models {
-	db = dbPool
+// Models, which holds Postgres backed repositories of all the types we want to be
+// available to our application. Passwords of new users are hashed with hasher,
+// secrets of second factors are encrypted with box, and changes recorded in
+// the audit trail are passed to publisher.
+func New(dbPool *sqlx.DB, hasher PasswordHasher, box *SecretBox, publisher AuditPublisher) Models {
+	return newModels(dbPool, hasher, box, Auditor{Publisher: publisher, db: dbPool})
+}
+
+// As returns Models which record actor as the one making the changes in the audit trail.
+// Models without the database, such as the in-memory ones, record no audit
+// trail and are returned as they are.
+func (m Models) As(actor Actor) Models {
+	if m.db == nil {
+		return m
+	}
+	return newModels(m.db, m.hasher, m.box, Auditor{Actor: actor, Publisher: m.publisher, db: m.db})
+}
 
+func newModels(dbPool *sqlx.DB, hasher PasswordHasher, box *SecretBox, auditor Auditor) Models {
 	return Models{
-		User: User{},
+		User:         NewUserRepository(dbPool, hasher, auditor),
+		Token:        NewTokenRepository(dbPool),
+		LoginAttempt: NewLoginAttemptRepository(dbPool),
+		OneTimeToken: NewOneTimeTokenRepository(dbPool),
+		Role:         NewRoleRepository(dbPool, auditor),
+		TwoFactor:    NewTwoFactorRepository(dbPool, box, auditor),
+		Audit:        NewAuditRepository(dbPool),
+		AuthCode:     NewAuthorizationCodeRepository(dbPool),
+		APIKey:       NewAPIKeyRepository(dbPool, auditor),
+
+		db:        dbPool,
+		hasher:    hasher,
+		box:       box,
+		publisher: auditor.Publisher,
 	}
 }
 
@@ -45,14 +83,53 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// UserRepository is the interface of the storage which holds users.
+type UserRepository interface {
+	// GetAll returns all users.
+	GetAll() ([]*User, error)
+	// GetPage returns one page of users ordered by last name, with
+	// the total number of users.
+	GetPage(limit, offset int) ([]*User, int, error)
+	// GetByEmail returns one user by Email.
+	GetByEmail(email string) (*User, error)
+	// GetOne returns one user by ID.
+	GetOne(id int) (*User, error)
+	// Update updates one user, using the information stored in user.
+	Update(user User) error
+	// Delete deletes one user by ID.
+	Delete(id int) error
+	// Insert puts new user to the storage and returns id of inserted user.
+	Insert(user User) (int, error)
+	// ResetPassword changes password of the user with the given ID, or returns
+	// sql.ErrNoRows if there is no such user.
+	ResetPassword(id int, password string) error
+	// Rehash replaces the password hash of the user with the given ID with
+	// a new hash of the same password. It isn't a change of the password,
+	// so it isn't recorded in the audit trail.
+	Rehash(id int, hash string) error
+}
+
+// postgresUserRepository is the UserRepository backed by Postgres.
+type postgresUserRepository struct {
+	db      *sqlx.DB
+	hasher  PasswordHasher
+	auditor Auditor
+}
+
+// NewUserRepository creates UserRepository which stores users in Postgres,
+// with passwords hashed by hasher and changes recorded by auditor.
+func NewUserRepository(db *sqlx.DB, hasher PasswordHasher, auditor Auditor) UserRepository {
+	return &postgresUserRepository{db: db, hasher: hasher, auditor: auditor}
+}
+
 // GetAll returns all users.
-func (u *User) GetAll() ([]*User, error) {
+func (r *postgresUserRepository) GetAll() ([]*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	var users []*User
 
-	if err := db.SelectContext(ctx, &users, getAllUsersQuery); err != nil {
+	if err := r.db.SelectContext(ctx, &users, getAllUsersQuery); err != nil {
 		log.Printf("Query failed: %v\n", err)
 		return nil, err
 	}
@@ -60,13 +137,35 @@ func (u *User) GetAll() ([]*User, error) {
 	return users, nil
 }
 
+// GetPage returns one page of users ordered by last name, with
+// the total number of users.
+func (r *postgresUserRepository) GetPage(limit, offset int) ([]*User, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var total int
+	if err := r.db.GetContext(ctx, &total, countUsersQuery); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, 0, err
+	}
+
+	users := []*User{}
+
+	if err := r.db.SelectContext(ctx, &users, getUsersPageQuery, limit, offset); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
 // GetByEmail returns one user by Email.
-func (u *User) GetByEmail(email string) (*User, error) {
+func (r *postgresUserRepository) GetByEmail(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	var user User
-	if err := db.GetContext(ctx, &user, getUserByEmailQuery, email); err != nil {
+	if err := r.db.GetContext(ctx, &user, getUserByEmailQuery, email); err != nil {
 		log.Printf("Query failed: %v\n", err)
 		return nil, err
 	}
@@ -75,12 +174,12 @@ func (u *User) GetByEmail(email string) (*User, error) {
 }
 
 // GetOne returns one user by ID
-func (u *User) GetOne(id int) (*User, error) {
+func (r *postgresUserRepository) GetOne(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	var user User
-	if err := db.GetContext(ctx, &user, getUserByIDQuery, id); err != nil {
+	if err := r.db.GetContext(ctx, &user, getUserByIDQuery, id); err != nil {
 		log.Printf("Query failed: %v\n", err)
 		return nil, err
 	}
@@ -89,120 +188,198 @@ func (u *User) GetOne(id int) (*User, error) {
 }
 
 // Update updates one user in the database, using the information
-// stored in the receiver u
-func (u *User) Update() error {
+// stored in user
+func (r *postgresUserRepository) Update(user User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var before User
+	if err := tx.GetContext(ctx, &before, getUserByIDQuery, user.ID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	user.CreatedAt = before.CreatedAt
+	user.UpdatedAt = time.Now()
+
 	args := []any{
-		u.Email,
-		u.FirstName,
-		u.LastName,
-		u.Active,
-		time.Now(),
-		u.ID,
-	}
-	if _, err := db.ExecContext(ctx, updateUserByIDQuery, args...); err != nil {
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Active,
+		user.UpdatedAt,
+		user.ID,
+	}
+	if _, err := tx.ExecContext(ctx, updateUserByIDQuery, args...); err != nil {
 		log.Printf("Query failed: %v\n", err)
 		return err
 	}
 
+	event, err := r.auditor.record(ctx, tx, user.ID, AuditUserUpdate, before, user)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.auditor.publish(event)
+
 	return nil
 }
 
-// Delete deletes one user from database, by User.ID.
-func (u *User) Delete() error {
+// Delete deletes one user from database, by id.
+func (r *postgresUserRepository) Delete(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	if _, err := db.ExecContext(ctx, deleteUserByIDQuery, u.ID); err != nil {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var before User
+	if err := tx.GetContext(ctx, &before, getUserByIDQuery, id); err != nil {
 		log.Printf("Query failed: %v\n", err)
 		return err
 	}
 
-	return nil
-}
+	if _, err := tx.ExecContext(ctx, deleteUserByIDQuery, id); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
 
-// DeleteOne deletes user from database, by id.
-func (u *User) DeleteOne(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+	event, err := r.auditor.record(ctx, tx, id, AuditUserDelete, before, nil)
+	if err != nil {
+		return err
+	}
 
-	if _, err := db.ExecContext(ctx, deleteUserByIDQuery, id); err != nil {
-		log.Printf("Query failed: %v\n", err)
+	if err := tx.Commit(); err != nil {
 		return err
 	}
+	r.auditor.publish(event)
 
 	return nil
 }
 
 // Insert puts new user to the database and returns id of inserted user.
-func (u *User) Insert(user User) (int, error) {
+// The id is read back with RETURNING, since the pgx driver does not
+// support LastInsertId.
+func (r *postgresUserRepository) Insert(user User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	hashedPassword, err := r.hasher.Hash(user.Password)
 	if err != nil {
 		log.Printf("Password hashing failed: %v\n", err)
 		return 0, err
 	}
 
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+
 	args := []any{
-		u.Email,
-		u.FirstName,
-		u.LastName,
+		user.Email,
+		user.FirstName,
+		user.LastName,
 		hashedPassword,
-		u.Active,
-		time.Now(),
-		time.Now(),
+		user.Active,
+		user.CreatedAt,
+		user.UpdatedAt,
 	}
 
-	ret, err := db.ExecContext(ctx, insertNewUserQuery, args...)
-	if err != nil {
+	if err := tx.GetContext(ctx, &user.ID, insertNewUserQuery, args...); err != nil {
 		log.Printf("Query failed: %v\n", err)
 		return 0, err
 	}
 
-	insertedID, err := ret.LastInsertId()
+	event, err := r.auditor.record(ctx, tx, user.ID, AuditUserCreate, nil, user)
 	if err != nil {
 		return 0, err
 	}
 
-	return int(insertedID), nil
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	r.auditor.publish(event)
+
+	return user.ID, nil
 }
 
-// ResetPassword changes user's password.
-func (u *User) ResetPassword(password string) error {
+// ResetPassword changes user's password. The audit trail only tells
+// the password was changed, hashes are never recorded.
+func (r *postgresUserRepository) ResetPassword(id int, password string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := r.hasher.Hash(password)
 	if err != nil {
 		log.Printf("Password hashing failed: %v\n", err)
 		return err
 	}
 
-	_, err = db.ExecContext(ctx, updateUserPasswordQuery, hashedPassword, u.ID)
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, updateUserPasswordQuery, hashedPassword, id)
 	if err != nil {
 		log.Printf("Query failed: %v\n", err)
 		return err
 	}
 
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	event, err := r.auditor.record(ctx, tx, id, AuditUserPassword, nil, map[string]bool{"password_changed": true})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.auditor.publish(event)
+
+	return nil
+}
+
+// Rehash replaces the password hash of the user with a new hash of the same password.
+func (r *postgresUserRepository) Rehash(id int, hash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, updateUserPasswordQuery, hash, id); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
 	return nil
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
+// PasswordMatches compares a user supplied password with the hash we have stored
+// for a given user in the database, whichever supported algorithm made the hash.
+// If the password and hash match, we return true; otherwise, we return false.
 func (u *User) PasswordMatches(plainText string) (bool, error) {
-	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText)); err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-	return true, nil
+	return passwordMatches(u.Password, plainText)
 }
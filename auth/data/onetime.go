@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Scopes of one-time tokens.
+const (
+	ScopeVerification  = "verification"
+	ScopePasswordReset = "password-reset"
+	ScopeMFAChallenge  = "mfa-challenge"
+)
+
+// OneTimeToken is the structure which holds one single-use token from the
+// database, such as the one sent in an email verification link. As with
+// refresh tokens, only the SHA-256 hash of the token is stored.
+type OneTimeToken struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Scope     string    `json:"scope" db:"scope"`
+	PlainText string    `json:"token" db:"-"`
+	Hash      []byte    `json:"-" db:"token_hash"`
+	Expiry    time.Time `json:"expiry" db:"expiry"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// OneTimeTokenRepository is the interface of the storage which holds single-use tokens.
+type OneTimeTokenRepository interface {
+	// Insert puts new single-use token to the storage.
+	Insert(token OneTimeToken) error
+	// Get returns not expired token of the scope by its plain text, without using it up.
+	Get(scope, plainText string) (*OneTimeToken, error)
+	// Consume deletes not expired token of the scope by its plain text and
+	// returns it, so every token can be used only once.
+	Consume(scope, plainText string) (*OneTimeToken, error)
+	// DeleteAllForUser deletes every token of the scope issued to the user with the given id.
+	DeleteAllForUser(scope string, userID int) error
+}
+
+// postgresOneTimeTokenRepository is the OneTimeTokenRepository backed by Postgres.
+type postgresOneTimeTokenRepository struct {
+	db *sqlx.DB
+}
+
+// NewOneTimeTokenRepository creates OneTimeTokenRepository which stores
+// single-use tokens in Postgres.
+func NewOneTimeTokenRepository(db *sqlx.DB) OneTimeTokenRepository {
+	return &postgresOneTimeTokenRepository{db: db}
+}
+
+// GenerateOneTimeToken creates a new random single-use token of the scope for
+// the user with the given id, which expires after ttl. The token is not saved
+// to the database.
+func GenerateOneTimeToken(userID int, scope string, ttl time.Duration) (*OneTimeToken, error) {
+	plainText, err := randomPlainText()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &OneTimeToken{
+		UserID:    userID,
+		Scope:     scope,
+		PlainText: plainText,
+		Hash:      hashToken(plainText),
+		Expiry:    time.Now().Add(ttl),
+	}
+
+	return token, nil
+}
+
+// Insert puts new single-use token to the database.
+func (r *postgresOneTimeTokenRepository) Insert(token OneTimeToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	args := []any{
+		token.UserID,
+		token.Scope,
+		token.Hash,
+		token.Expiry,
+		time.Now(),
+	}
+	if _, err := r.db.ExecContext(ctx, insertOneTimeTokenQuery, args...); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Get returns not expired token of the scope by its plain text, without using it up.
+func (r *postgresOneTimeTokenRepository) Get(scope, plainText string) (*OneTimeToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var token OneTimeToken
+	if err := r.db.GetContext(
+		ctx,
+		&token,
+		getOneTimeTokenQuery,
+		scope,
+		hashToken(plainText),
+		time.Now(),
+	); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Consume deletes not expired token of the scope by its plain text and returns it.
+func (r *postgresOneTimeTokenRepository) Consume(scope, plainText string) (*OneTimeToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var token OneTimeToken
+	if err := r.db.GetContext(
+		ctx,
+		&token,
+		consumeOneTimeTokenQuery,
+		scope,
+		hashToken(plainText),
+		time.Now(),
+	); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// DeleteAllForUser deletes every token of the scope issued to the user with the given id.
+func (r *postgresOneTimeTokenRepository) DeleteAllForUser(scope string, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, deleteOneTimeTokensByUserIDQuery, scope, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
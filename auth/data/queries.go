@@ -17,6 +17,32 @@ ORDER BY
     last_name
 `
 
+	getUsersPageQuery = `
+SELECT
+	id,
+	email,
+	first_name,
+	last_name,
+	password,
+	active,
+	created_at,
+	updated_at
+FROM
+	users
+ORDER BY
+	last_name,
+	id
+LIMIT $1
+OFFSET $2
+`
+
+	countUsersQuery = `
+SELECT
+	count(*)
+FROM
+	users
+`
+
 	getUserByEmailQuery = `
 SELECT
 	id,
@@ -93,5 +119,586 @@ SET
     password = $1
 WHERE
     id = $2
+`
+
+	insertTokenQuery = `
+INSERT
+INTO
+	refresh_tokens(
+		user_id,
+		token_hash,
+		expiry,
+		created_at,
+		updated_at
+	)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+	getTokenByHashQuery = `
+SELECT
+	id,
+	user_id,
+	token_hash,
+	expiry,
+	created_at,
+	updated_at
+FROM
+	refresh_tokens
+WHERE
+	token_hash = $1
+	AND expiry > $2
+`
+
+	consumeTokenQuery = `
+DELETE
+FROM
+	refresh_tokens
+WHERE
+	token_hash = $1
+	AND expiry > $2
+RETURNING
+	id,
+	user_id,
+	token_hash,
+	expiry,
+	created_at,
+	updated_at
+`
+
+	deleteTokenByHashQuery = `
+DELETE
+FROM
+	refresh_tokens
+WHERE
+	token_hash = $1
+`
+
+	deleteTokensByUserIDQuery = `
+DELETE
+FROM
+	refresh_tokens
+WHERE
+	user_id = $1
+`
+
+	getLoginAttemptQuery = `
+SELECT
+	scope,
+	subject,
+	failures,
+	last_failure_at,
+	locked_until
+FROM
+	login_attempts
+WHERE
+	scope = $1
+	AND subject = $2
+`
+
+	recordLoginFailureQuery = `
+INSERT
+INTO
+	login_attempts(
+		scope,
+		subject,
+		failures,
+		last_failure_at
+	)
+VALUES ($1, $2, 1, $3)
+ON CONFLICT (scope, subject) DO UPDATE
+SET
+	failures = login_attempts.failures + 1,
+	last_failure_at = EXCLUDED.last_failure_at
+RETURNING
+	scope,
+	subject,
+	failures,
+	last_failure_at,
+	locked_until
+`
+
+	lockLoginAttemptQuery = `
+UPDATE
+	login_attempts
+SET
+	locked_until = $1
+WHERE
+	scope = $2
+	AND subject = $3
+`
+
+	deleteLoginAttemptQuery = `
+DELETE
+FROM
+	login_attempts
+WHERE
+	scope = $1
+	AND subject = $2
+`
+
+	insertOneTimeTokenQuery = `
+INSERT
+INTO
+	one_time_tokens(
+		user_id,
+		scope,
+		token_hash,
+		expiry,
+		created_at
+	)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+	consumeOneTimeTokenQuery = `
+DELETE
+FROM
+	one_time_tokens
+WHERE
+	scope = $1
+	AND token_hash = $2
+	AND expiry > $3
+RETURNING
+	id,
+	user_id,
+	scope,
+	token_hash,
+	expiry,
+	created_at
+`
+
+	deleteOneTimeTokensByUserIDQuery = `
+DELETE
+FROM
+	one_time_tokens
+WHERE
+	scope = $1
+	AND user_id = $2
+`
+
+	getAllRolesQuery = `
+SELECT
+	id,
+	name,
+	description,
+	created_at
+FROM
+	roles
+ORDER BY
+	name
+`
+
+	getAllRolePermissionsQuery = `
+SELECT
+	rp.role_id,
+	p.name AS permission
+FROM
+	role_permissions rp
+	JOIN permissions p ON p.id = rp.permission_id
+ORDER BY
+	p.name
+`
+
+	getRolesForUserQuery = `
+SELECT
+	r.name
+FROM
+	user_roles ur
+	JOIN roles r ON r.id = ur.role_id
+WHERE
+	ur.user_id = $1
+ORDER BY
+	r.name
+`
+
+	getPermissionsForUserQuery = `
+SELECT DISTINCT
+	p.name
+FROM
+	user_roles ur
+	JOIN role_permissions rp ON rp.role_id = ur.role_id
+	JOIN permissions p ON p.id = rp.permission_id
+WHERE
+	ur.user_id = $1
+ORDER BY
+	p.name
+`
+
+	deleteUserRolesQuery = `
+DELETE
+FROM
+	user_roles
+WHERE
+	user_id = $1
+`
+
+	insertUserRolesQuery = `
+INSERT
+INTO
+	user_roles(
+		user_id,
+		role_id
+	)
+SELECT
+	$1,
+	id
+FROM
+	roles
+WHERE
+	name = ANY($2)
+`
+
+	getTOTPQuery = `
+SELECT
+	user_id,
+	secret,
+	confirmed,
+	last_used_step,
+	created_at,
+	confirmed_at
+FROM
+	user_totp
+WHERE
+	user_id = $1
+`
+
+	upsertTOTPQuery = `
+INSERT
+INTO
+	user_totp(
+		user_id,
+		secret,
+		confirmed,
+		last_used_step,
+		created_at
+	)
+VALUES ($1, $2, FALSE, 0, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET
+	secret = EXCLUDED.secret,
+	confirmed = FALSE,
+	last_used_step = 0,
+	created_at = EXCLUDED.created_at,
+	confirmed_at = NULL
+`
+
+	confirmTOTPQuery = `
+UPDATE
+	user_totp
+SET
+	confirmed = TRUE,
+	confirmed_at = $1
+WHERE
+	user_id = $2
+`
+
+	useTOTPStepQuery = `
+UPDATE
+	user_totp
+SET
+	last_used_step = $1
+WHERE
+	user_id = $2
+	AND last_used_step < $1
+`
+
+	deleteTOTPQuery = `
+DELETE
+FROM
+	user_totp
+WHERE
+	user_id = $1
+`
+
+	insertRecoveryCodeQuery = `
+INSERT
+INTO
+	recovery_codes(
+		user_id,
+		code_hash,
+		created_at
+	)
+VALUES ($1, $2, $3)
+`
+
+	useRecoveryCodeQuery = `
+UPDATE
+	recovery_codes
+SET
+	used_at = $1
+WHERE
+	user_id = $2
+	AND code_hash = $3
+	AND used_at IS NULL
+`
+
+	deleteRecoveryCodesQuery = `
+DELETE
+FROM
+	recovery_codes
+WHERE
+	user_id = $1
+`
+
+	getOneTimeTokenQuery = `
+SELECT
+	id,
+	user_id,
+	scope,
+	token_hash,
+	expiry,
+	created_at
+FROM
+	one_time_tokens
+WHERE
+	scope = $1
+	AND token_hash = $2
+	AND expiry > $3
+`
+
+	insertAuditEventQuery = `
+INSERT
+INTO
+	user_audit(
+		user_id,
+		actor_id,
+		actor_ip,
+		action,
+		before,
+		after,
+		created_at
+	)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING
+	id,
+	created_at
+`
+
+	countAuditEventsQuery = `
+SELECT
+	count(*)
+FROM
+	user_audit
+WHERE
+	user_id = $1
+`
+
+	getAuditEventsPageQuery = `
+SELECT
+	id,
+	user_id,
+	actor_id,
+	actor_ip,
+	action,
+	before,
+	after,
+	created_at
+FROM
+	user_audit
+WHERE
+	user_id = $1
+ORDER BY
+	id DESC
+LIMIT $2
+OFFSET $3
+`
+
+	insertAuditOutboxQuery = `
+INSERT
+INTO
+	user_audit_outbox(
+		event_id
+	)
+VALUES ($1)
+`
+
+	deleteAuditOutboxQuery = `
+DELETE
+FROM
+	user_audit_outbox
+WHERE
+	event_id = $1
+`
+
+	getUnpublishedAuditEventsQuery = `
+SELECT
+	a.id,
+	a.user_id,
+	a.actor_id,
+	a.actor_ip,
+	a.action,
+	a.before,
+	a.after,
+	a.created_at
+FROM
+	user_audit_outbox o
+	JOIN user_audit a ON a.id = o.event_id
+WHERE
+	a.created_at < $1
+ORDER BY
+	a.id
+LIMIT $2
+`
+
+	insertAuthorizationCodeQuery = `
+INSERT
+INTO
+	authorization_codes(
+		code_hash,
+		user_id,
+		client_id,
+		redirect_uri,
+		scope,
+		nonce,
+		code_challenge,
+		auth_time,
+		expiry,
+		created_at
+	)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+	consumeAuthorizationCodeQuery = `
+DELETE
+FROM
+	authorization_codes
+WHERE
+	code_hash = $1
+	AND expiry > $2
+RETURNING
+	code_hash,
+	user_id,
+	client_id,
+	redirect_uri,
+	scope,
+	nonce,
+	code_challenge,
+	auth_time,
+	expiry
+`
+
+	insertAPIKeyQuery = `
+INSERT
+INTO
+	api_keys(
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expiry,
+		created_at
+	)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING
+	id
+`
+
+	getAPIKeysForUserQuery = `
+SELECT
+	id,
+	user_id,
+	name,
+	prefix,
+	key_hash,
+	scopes,
+	expiry,
+	last_used_at,
+	created_at
+FROM
+	api_keys
+WHERE
+	user_id = $1
+ORDER BY
+	id DESC
+`
+
+	getAPIKeyByHashQuery = `
+SELECT
+	id,
+	user_id,
+	name,
+	prefix,
+	key_hash,
+	scopes,
+	expiry,
+	last_used_at,
+	created_at
+FROM
+	api_keys
+WHERE
+	key_hash = $1
+	AND (expiry IS NULL OR expiry > $2)
+`
+
+	touchAPIKeyQuery = `
+UPDATE
+	api_keys
+SET
+	last_used_at = $1
+WHERE
+	id = $2
+`
+
+	deleteAPIKeyQuery = `
+DELETE
+FROM
+	api_keys
+WHERE
+	id = $1
+	AND user_id = $2
+RETURNING
+	id,
+	user_id,
+	name,
+	prefix,
+	key_hash,
+	scopes,
+	expiry,
+	last_used_at,
+	created_at
+`
+
+	createSchemaMigrationsQuery = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name character varying(255) NOT NULL,
+	applied_at timestamp without time zone NOT NULL
+)
+`
+
+	getAppliedMigrationsQuery = `
+SELECT
+	version,
+	applied_at
+FROM
+	schema_migrations
+ORDER BY
+	version
+`
+
+	insertSchemaMigrationQuery = `
+INSERT INTO schema_migrations (
+	version,
+	name,
+	applied_at
+)
+VALUES
+	($1, $2, $3)
+`
+
+	deleteSchemaMigrationQuery = `
+DELETE
+FROM
+	schema_migrations
+WHERE
+	version = $1
+`
+
+	advisoryLockQuery = `
+SELECT pg_advisory_lock($1)
+`
+
+	advisoryUnlockQuery = `
+SELECT pg_advisory_unlock($1)
 `
 )
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Names of the roles every installation has.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Names of the permissions which guard the user management API.
+const (
+	PermissionUsersRead   = "users:read"
+	PermissionUsersWrite  = "users:write"
+	PermissionUsersDelete = "users:delete"
+	PermissionRolesWrite  = "roles:write"
+)
+
+// ErrUnknownRole is returned when a role being assigned doesn't exist.
+var ErrUnknownRole = errors.New("unknown role")
+
+// Role is the structure which holds one role from the database, with
+// names of the permissions it grants.
+type Role struct {
+	ID          int       `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Permissions []string  `json:"permissions" db:"-"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// RoleRepository is the interface of the storage which holds roles, their
+// permissions and the roles assigned to users.
+type RoleRepository interface {
+	// GetAll returns all roles with their permissions.
+	GetAll() ([]*Role, error)
+	// GetForUser returns names of the roles assigned to the user with the given id.
+	GetForUser(userID int) ([]string, error)
+	// GetPermissionsForUser returns names of all permissions granted to the
+	// user with the given id through the user's roles.
+	GetPermissionsForUser(userID int) ([]string, error)
+	// SetForUser replaces roles of the user with the given id with the named ones.
+	SetForUser(userID int, roles []string) error
+}
+
+// postgresRoleRepository is the RoleRepository backed by Postgres.
+type postgresRoleRepository struct {
+	db      *sqlx.DB
+	auditor Auditor
+}
+
+// NewRoleRepository creates RoleRepository which stores roles in Postgres,
+// with changes of assigned roles recorded by auditor.
+func NewRoleRepository(db *sqlx.DB, auditor Auditor) RoleRepository {
+	return &postgresRoleRepository{db: db, auditor: auditor}
+}
+
+// GetAll returns all roles with their permissions.
+func (r *postgresRoleRepository) GetAll() ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var roles []*Role
+	if err := r.db.SelectContext(ctx, &roles, getAllRolesQuery); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	var grants []struct {
+		RoleID     int    `db:"role_id"`
+		Permission string `db:"permission"`
+	}
+	if err := r.db.SelectContext(ctx, &grants, getAllRolePermissionsQuery); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	byID := make(map[int]*Role, len(roles))
+	for _, role := range roles {
+		role.Permissions = []string{}
+		byID[role.ID] = role
+	}
+	for _, grant := range grants {
+		if role, ok := byID[grant.RoleID]; ok {
+			role.Permissions = append(role.Permissions, grant.Permission)
+		}
+	}
+
+	return roles, nil
+}
+
+// GetForUser returns names of the roles assigned to the user with the given id.
+func (r *postgresRoleRepository) GetForUser(userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	roles := []string{}
+	if err := r.db.SelectContext(ctx, &roles, getRolesForUserQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetPermissionsForUser returns names of all permissions granted to the
+// user with the given id through the user's roles.
+func (r *postgresRoleRepository) GetPermissionsForUser(userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	permissions := []string{}
+	if err := r.db.SelectContext(ctx, &permissions, getPermissionsForUserQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// SetForUser replaces roles of the user with the given id with the named ones.
+// Nothing changes and ErrUnknownRole is returned if any of the roles doesn't exist.
+func (r *postgresRoleRepository) SetForUser(userID int, roles []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	unique := make(map[string]bool, len(roles))
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !unique[role] {
+			unique[role] = true
+			names = append(names, role)
+		}
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before := []string{}
+	if err := tx.SelectContext(ctx, &before, getRolesForUserQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteUserRolesQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, insertUserRolesQuery, userID, names)
+	if err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(inserted) != len(names) {
+		return ErrUnknownRole
+	}
+
+	event, err := r.auditor.record(ctx, tx, userID, AuditUserRoles, before, names)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.auditor.publish(event)
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// SecretBox encrypts secrets before they are stored in the database, with
+// AES-256-GCM. The random nonce is prepended to every ciphertext.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates SecretBox with the 32 bytes long key.
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts the plain text.
+func (b *SecretBox) Seal(plainText []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return b.aead.Seal(nonce, nonce, plainText, nil), nil
+}
+
+// Open decrypts the cipher text made by Seal.
+func (b *SecretBox) Open(cipherText []byte) ([]byte, error) {
+	nonceSize := b.aead.NonceSize()
+	if len(cipherText) < nonceSize {
+		return nil, errors.New("malformed cipher text")
+	}
+
+	return b.aead.Open(nil, cipherText[:nonceSize], cipherText[nonceSize:], nil)
+}
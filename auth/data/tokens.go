@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Token is the structure which holds one refresh token from the database.
+// Only the SHA-256 hash of the token is stored, the plain text is handed
+// out to the client once and never persisted.
+type Token struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	PlainText string    `json:"token" db:"-"`
+	Hash      []byte    `json:"-" db:"token_hash"`
+	Expiry    time.Time `json:"expiry" db:"expiry"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TokenRepository is the interface of the storage which holds refresh tokens.
+type TokenRepository interface {
+	// Insert puts new refresh token to the storage.
+	Insert(token Token) error
+	// GetByPlainText returns one not expired refresh token by its plain text.
+	GetByPlainText(plainText string) (*Token, error)
+	// Consume deletes one not expired refresh token by its plain text and
+	// returns it, so of concurrent calls with the same token only one gets it.
+	Consume(plainText string) (*Token, error)
+	// DeleteByPlainText deletes one refresh token by its plain text.
+	DeleteByPlainText(plainText string) error
+	// DeleteAllForUser deletes every refresh token issued to the user with the given id.
+	DeleteAllForUser(userID int) error
+}
+
+// postgresTokenRepository is the TokenRepository backed by Postgres.
+type postgresTokenRepository struct {
+	db *sqlx.DB
+}
+
+// NewTokenRepository creates TokenRepository which stores refresh tokens in Postgres.
+func NewTokenRepository(db *sqlx.DB) TokenRepository {
+	return &postgresTokenRepository{db: db}
+}
+
+// GenerateToken creates a new random refresh token for the user with the
+// given id, which expires after ttl. The token is not saved to the database.
+func GenerateToken(userID int, ttl time.Duration) (*Token, error) {
+	plainText, err := randomPlainText()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{
+		UserID:    userID,
+		PlainText: plainText,
+		Hash:      hashToken(plainText),
+		Expiry:    time.Now().Add(ttl),
+	}
+
+	return token, nil
+}
+
+// Insert puts new refresh token to the database.
+func (r *postgresTokenRepository) Insert(token Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	args := []any{
+		token.UserID,
+		token.Hash,
+		token.Expiry,
+		time.Now(),
+		time.Now(),
+	}
+	if _, err := r.db.ExecContext(ctx, insertTokenQuery, args...); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetByPlainText returns one not expired refresh token by its plain text.
+func (r *postgresTokenRepository) GetByPlainText(plainText string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var token Token
+	if err := r.db.GetContext(
+		ctx,
+		&token,
+		getTokenByHashQuery,
+		hashToken(plainText),
+		time.Now(),
+	); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Consume deletes one not expired refresh token by its plain text and returns it.
+func (r *postgresTokenRepository) Consume(plainText string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var token Token
+	if err := r.db.GetContext(
+		ctx,
+		&token,
+		consumeTokenQuery,
+		hashToken(plainText),
+		time.Now(),
+	); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// DeleteByPlainText deletes one refresh token from database, by its plain text.
+func (r *postgresTokenRepository) DeleteByPlainText(plainText string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, deleteTokenByHashQuery, hashToken(plainText)); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAllForUser deletes every refresh token issued to the user with the given id.
+func (r *postgresTokenRepository) DeleteAllForUser(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, deleteTokensByUserIDQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// randomPlainText gets 256 bits of randomness encoded as URL-safe text.
+func randomPlainText() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// hashToken gets SHA-256 hash of the token plain text.
+func hashToken(plainText string) []byte {
+	hash := sha256.Sum256([]byte(plainText))
+	return hash[:]
+}
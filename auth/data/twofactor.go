@@ -0,0 +1,274 @@
+package data
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TOTP is the structure which holds TOTP second factor of one user.
+// The secret is kept encrypted in the database.
+type TOTP struct {
+	UserID          int        `json:"user_id" db:"user_id"`
+	Secret          string     `json:"-" db:"-"`
+	EncryptedSecret []byte     `json:"-" db:"secret"`
+	Confirmed       bool       `json:"confirmed" db:"confirmed"`
+	LastUsedStep    int64      `json:"-" db:"last_used_step"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	ConfirmedAt     *time.Time `json:"confirmed_at,omitempty" db:"confirmed_at"`
+}
+
+// TwoFactorRepository is the interface of the storage which holds TOTP
+// secrets and recovery codes of users.
+type TwoFactorRepository interface {
+	// GetTOTP returns TOTP of the user with the given id.
+	GetTOTP(userID int) (*TOTP, error)
+	// SaveTOTP replaces TOTP of the user with a new not confirmed one.
+	SaveTOTP(userID int, secret string) error
+	// ConfirmTOTP marks TOTP of the user as confirmed, so it is required on login.
+	ConfirmTOTP(userID int) error
+	// UseStep records the time step of an accepted code. It returns false if
+	// the same or a later step was already used, so codes can't be replayed.
+	UseStep(userID int, step int64) (bool, error)
+	// DeleteTOTP deletes TOTP and recovery codes of the user.
+	DeleteTOTP(userID int) error
+	// ReplaceRecoveryCodes replaces recovery codes of the user.
+	ReplaceRecoveryCodes(userID int, codes []string) error
+	// UseRecoveryCode marks the recovery code of the user as used. It returns
+	// false if there is no such unused code.
+	UseRecoveryCode(userID int, code string) (bool, error)
+}
+
+// postgresTwoFactorRepository is the TwoFactorRepository backed by Postgres.
+type postgresTwoFactorRepository struct {
+	db      *sqlx.DB
+	box     *SecretBox
+	auditor Auditor
+}
+
+// NewTwoFactorRepository creates TwoFactorRepository which stores second
+// factors in Postgres, with TOTP secrets encrypted by box and changes
+// recorded by auditor.
+func NewTwoFactorRepository(db *sqlx.DB, box *SecretBox, auditor Auditor) TwoFactorRepository {
+	return &postgresTwoFactorRepository{db: db, box: box, auditor: auditor}
+}
+
+// GetTOTP returns TOTP of the user with the given id, with decrypted secret.
+func (r *postgresTwoFactorRepository) GetTOTP(userID int) (*TOTP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var totp TOTP
+	if err := r.db.GetContext(ctx, &totp, getTOTPQuery, userID); err != nil {
+		return nil, err
+	}
+
+	secret, err := r.box.Open(totp.EncryptedSecret)
+	if err != nil {
+		log.Printf("Decrypting TOTP secret of user %d failed: %v\n", userID, err)
+		return nil, err
+	}
+	totp.Secret = string(secret)
+
+	return &totp, nil
+}
+
+// SaveTOTP replaces TOTP of the user with a new not confirmed one.
+func (r *postgresTwoFactorRepository) SaveTOTP(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	encrypted, err := r.box.Seal([]byte(secret))
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, upsertTOTPQuery, userID, encrypted, time.Now()); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	event, err := r.auditor.record(ctx, tx, userID, AuditTOTPEnroll, nil, map[string]bool{"confirmed": false})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.auditor.publish(event)
+
+	return nil
+}
+
+// ConfirmTOTP marks TOTP of the user as confirmed.
+func (r *postgresTwoFactorRepository) ConfirmTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, confirmTOTPQuery, time.Now(), userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	event, err := r.auditor.record(
+		ctx,
+		tx,
+		userID,
+		AuditTOTPConfirm,
+		map[string]bool{"confirmed": false},
+		map[string]bool{"confirmed": true},
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.auditor.publish(event)
+
+	return nil
+}
+
+// UseStep records the time step of an accepted code.
+func (r *postgresTwoFactorRepository) UseStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, useTOTPStepQuery, step, userID)
+	if err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return false, err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return updated == 1, nil
+}
+
+// DeleteTOTP deletes TOTP and recovery codes of the user.
+func (r *postgresTwoFactorRepository) DeleteTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteTOTPQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	event, err := r.auditor.record(ctx, tx, userID, AuditTOTPDisable, map[string]bool{"enabled": true}, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.auditor.publish(event)
+
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces recovery codes of the user. Only hashes
+// of the codes are stored.
+func (r *postgresTwoFactorRepository) ReplaceRecoveryCodes(userID int, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return err
+	}
+
+	for _, code := range codes {
+		if _, err := tx.ExecContext(
+			ctx,
+			insertRecoveryCodeQuery,
+			userID,
+			hashToken(normalizeRecoveryCode(code)),
+			time.Now(),
+		); err != nil {
+			log.Printf("Query failed: %v\n", err)
+			return err
+		}
+	}
+
+	// the codes themselves are secret, only their number is recorded
+	event, err := r.auditor.record(ctx, tx, userID, AuditRecoveryCodes, nil, map[string]int{"count": len(codes)})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.auditor.publish(event)
+
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code of the user as used.
+func (r *postgresTwoFactorRepository) UseRecoveryCode(userID int, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(
+		ctx,
+		useRecoveryCodeQuery,
+		time.Now(),
+		userID,
+		hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		log.Printf("Query failed: %v\n", err)
+		return false, err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return updated == 1, nil
+}
+
+// normalizeRecoveryCode makes codes typed with other case or without dashes match.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
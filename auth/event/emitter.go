@@ -0,0 +1,58 @@
+package event
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Payload is the message the listener service passes to the logger.
+type Payload struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+type Emitter struct {
+	connection *amqp.Connection
+}
+
+func (e *Emitter) setup() error {
+	channel, err := e.connection.Channel()
+	if err != nil {
+		return err
+	}
+
+	defer channel.Close()
+	return declareExchange(channel)
+}
+
+// Push publishes the JSON event to the logs_topic exchange with the severity
+// as the routing key.
+func (e *Emitter) Push(event []byte, severity string) error {
+	channel, err := e.connection.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	return channel.Publish(
+		"logs_topic",
+		severity,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         event,
+		},
+	)
+}
+
+func NewEventEmitter(conn *amqp.Connection) (Emitter, error) {
+	emitter := Emitter{
+		connection: conn,
+	}
+	if err := emitter.setup(); err != nil {
+		return Emitter{}, err
+	}
+
+	return emitter, nil
+}
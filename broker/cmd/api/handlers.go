@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/rpc"
 	"os"
+	"strings"
 	"time"
 
 	"broker/event"
@@ -18,12 +19,27 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
-	authURL = "http://auth/authenticate"
-	logURL  = "http://logger/log"
-	mailURL = "http://mail/send"
+	authURL      = "http://auth/authenticate"
+	validateURL  = "http://auth/validate"
+	logURL       = "http://logger/log"
+	logsURL      = "http://logger/logs"
+	retentionURL = "http://logger/retention"
+	mailURL      = "http://mail/send"
+	usersURL     = "http://auth/users"
+	signupURL    = "http://auth/signup"
+	verifyURL    = "http://auth/verify"
+	passwordURL  = "http://auth/password"
+	twoFactorURL = "http://auth/2fa"
+	apiKeysURL   = "http://auth/api-keys"
+
+	// secondFactorRequired is the message of the auth service when the
+	// login has to be completed with the second factor.
+	secondFactorRequired = "second factor required"
 )
 
 type RequestPayload struct {
@@ -31,16 +47,34 @@ type RequestPayload struct {
 	Auth   AuthPayload `json:"auth,omitempty"`
 	Log    LogPayload  `json:"log,omitempty"`
 	Mail   MailPayload `json:"mail,omitempty"`
+	User   UserPayload `json:"user,omitempty"`
+
+	TwoFactor TwoFactorPayload `json:"two_factor,omitempty"`
+	APIKey    APIKeyPayload    `json:"api_key,omitempty"`
+	LogQuery  LogQueryPayload  `json:"log_query,omitempty"`
+	LogStats  LogStatsPayload  `json:"log_stats,omitempty"`
+	Retention RetentionPayload `json:"retention,omitempty"`
 }
 
 type AuthPayload struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Challenge, Code and RecoveryCode complete the login of users
+	// with two-factor authentication.
+	Challenge    string `json:"challenge,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
 }
 
+// LogPayload is the log entry to write. Only name and data are required.
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Severity   string         `json:"severity,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	Timestamp  *time.Time     `json:"timestamp,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
 type MailPayload struct {
@@ -70,24 +104,49 @@ func (s *Service) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 
 	switch reqPayload.Action {
 	case "auth":
-		s.authenticate(w, reqPayload.Auth)
+		s.authenticate(w, r, authURL, AuthPayload{
+			Email:    reqPayload.Auth.Email,
+			Password: reqPayload.Auth.Password,
+		})
+	case "auth.2fa":
+		s.authenticate(w, r, authURL+"/2fa", AuthPayload{
+			Challenge:    reqPayload.Auth.Challenge,
+			Code:         reqPayload.Auth.Code,
+			RecoveryCode: reqPayload.Auth.RecoveryCode,
+		})
 	case "log":
-		s.logItemViaRPC(w, reqPayload.Log)
+		s.logItemViaRPC(r.Context(), w, reqPayload.Log)
+	case "log.query":
+		s.queryLogs(r.Context(), w, reqPayload.LogQuery)
+	case "log.stats":
+		s.logStats(r.Context(), w, reqPayload.LogStats)
+	case "log.retention", "log.retention.set", "log.retention.report":
+		s.handleRetentionAction(r.Context(), w, reqPayload.Action, reqPayload.Retention)
 	case "mail":
-		s.sendMail(w, reqPayload.Mail)
+		s.sendMail(r.Context(), w, reqPayload.Mail)
+	case "user.list", "user.get", "user.create", "user.update", "user.delete", "user.password",
+		"user.unlock", "user.roles", "user.roles.set", "user.audit":
+		s.handleUserAction(r.Context(), w, reqPayload.Action, reqPayload.User)
+	case "signup", "verify", "verify.resend", "password.forgot", "password.reset":
+		s.handleAccountAction(r.Context(), w, reqPayload.Action, reqPayload.User)
+	case "2fa.enroll", "2fa.confirm", "2fa.disable", "2fa.recovery":
+		s.handleTwoFactorAction(r.Context(), w, reqPayload.Action, reqPayload.TwoFactor)
+	case "apikey.list", "apikey.create", "apikey.revoke":
+		s.handleAPIKeyAction(r.Context(), w, reqPayload.Action, reqPayload.APIKey)
 	default:
 		_ = s.errorJSON(w, errors.New("unknown action"))
 	}
 }
 
-func (s *Service) logItem(w http.ResponseWriter, lp LogPayload) {
+func (s *Service) logItem(ctx context.Context, w http.ResponseWriter, lp LogPayload) {
 	jsonData, err := json.MarshalIndent(lp, "", "\t")
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	request, err := http.NewRequest(
+	request, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		logURL,
 		bytes.NewBuffer(jsonData),
@@ -98,6 +157,7 @@ func (s *Service) logItem(w http.ResponseWriter, lp LogPayload) {
 	}
 
 	request.Header.Set("Content-Type", "application/json")
+	setUserIDHeader(ctx, request)
 
 	client := &http.Client{}
 
@@ -120,7 +180,9 @@ func (s *Service) logItem(w http.ResponseWriter, lp LogPayload) {
 	_ = s.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (s *Service) authenticate(w http.ResponseWriter, ap AuthPayload) {
+// authenticate sends credentials to the endpoint of the auth service,
+// which is either the login itself or its second step.
+func (s *Service) authenticate(w http.ResponseWriter, r *http.Request, endpoint string, ap AuthPayload) {
 	// create some json we'll send to the auth microservice
 	jsonData, err := json.MarshalIndent(ap, "", "\t")
 	if err != nil {
@@ -130,13 +192,15 @@ func (s *Service) authenticate(w http.ResponseWriter, ap AuthPayload) {
 	// call the service
 	request, err := http.NewRequest(
 		"POST",
-		authURL,
+		endpoint,
 		bytes.NewBuffer(jsonData),
 	)
 	if err != nil {
 		_ = s.errorJSON(w, err)
 		return
 	}
+	// let the auth service throttle logins by the real client address
+	request.Header.Set("X-Real-IP", s.clientIP(r))
 
 	client := &http.Client{}
 	resp, err := client.Do(request)
@@ -150,6 +214,22 @@ func (s *Service) authenticate(w http.ResponseWriter, ap AuthPayload) {
 	if resp.StatusCode == http.StatusUnauthorized {
 		_ = s.errorJSON(w, errors.New("invalid credentials"))
 		return
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		var jsonFromService jsonResponse
+		_ = json.NewDecoder(resp.Body).Decode(&jsonFromService)
+		headers := http.Header{}
+		headers.Set("Retry-After", resp.Header.Get("Retry-After"))
+		_ = s.writeJSON(w, http.StatusTooManyRequests, jsonResponse{
+			Error:   true,
+			Message: jsonFromService.Message,
+		}, headers)
+		return
+	} else if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusForbidden {
+		// pass the reason, such as a wrong code, back to the caller
+		var jsonFromService jsonResponse
+		_ = json.NewDecoder(resp.Body).Decode(&jsonFromService)
+		_ = s.errorJSON(w, errors.New(jsonFromService.Message), resp.StatusCode)
+		return
 	} else if resp.StatusCode != http.StatusAccepted {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		log.Printf("%v", string(bodyBytes))
@@ -157,7 +237,13 @@ func (s *Service) authenticate(w http.ResponseWriter, ap AuthPayload) {
 		return
 	}
 
-	var jsonFromService jsonResponse
+	// keep data as raw JSON, so the user and tokens issued
+	// by the auth service are passed back unchanged
+	var jsonFromService struct {
+		Error   bool            `json:"error"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data,omitempty"`
+	}
 	// decode the json from the auth service
 	err = json.NewDecoder(resp.Body).Decode(&jsonFromService)
 	if err != nil {
@@ -166,27 +252,33 @@ func (s *Service) authenticate(w http.ResponseWriter, ap AuthPayload) {
 	}
 
 	if jsonFromService.Error {
-		_ = s.errorJSON(w, err, http.StatusUnauthorized)
+		_ = s.errorJSON(w, errors.New(jsonFromService.Message), http.StatusUnauthorized)
 		return
 	}
 
+	message := "Authenticated"
+	if jsonFromService.Message == secondFactorRequired {
+		message = jsonFromService.Message
+	}
+
 	payload := jsonResponse{
 		Error:   false,
-		Message: "Authenticated",
+		Message: message,
 		Data:    jsonFromService.Data,
 	}
 
 	_ = s.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (s *Service) sendMail(w http.ResponseWriter, mp MailPayload) {
+func (s *Service) sendMail(ctx context.Context, w http.ResponseWriter, mp MailPayload) {
 	jsonData, err := json.MarshalIndent(mp, "", "\t")
 	if err != nil {
 		_ = s.errorJSON(w, err)
 		return
 	}
 
-	request, err := http.NewRequest(
+	request, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		mailURL,
 		bytes.NewBuffer(jsonData),
@@ -196,6 +288,7 @@ func (s *Service) sendMail(w http.ResponseWriter, mp MailPayload) {
 		return
 	}
 	request.Header.Set("Content-Type", "application/json")
+	setUserIDHeader(ctx, request)
 
 	client := &http.Client{}
 	response, err := client.Do(request)
@@ -216,7 +309,7 @@ func (s *Service) sendMail(w http.ResponseWriter, mp MailPayload) {
 }
 
 func (s *Service) logEventViaRabbit(w http.ResponseWriter, lp LogPayload) {
-	if err := s.pushToQueue(lp.Name, lp.Data); err != nil {
+	if err := s.pushToQueue(lp); err != nil {
 		_ = s.errorJSON(w, err)
 		return
 	}
@@ -226,31 +319,48 @@ func (s *Service) logEventViaRabbit(w http.ResponseWriter, lp LogPayload) {
 	})
 }
 
-func (s *Service) pushToQueue(name, msg string) error {
+// pushToQueue publishes the entry with the routing key of its severity,
+// log.INFO if it has none.
+func (s *Service) pushToQueue(payload LogPayload) error {
 	emitter, err := event.NewEventEmitter(s.Rabbit)
 	if err != nil {
 		return err
 	}
 
-	payload := LogPayload{
-		Name: name,
-		Data: msg,
+	// the listener binds only the known severities, others would be lost
+	severity := strings.ToUpper(payload.Severity)
+	switch severity {
+	case "":
+		severity = "INFO"
+	case "DEBUG", "INFO", "WARNING", "ERROR":
+	default:
+		return errors.New("severity must be one of DEBUG, INFO, WARNING and ERROR")
 	}
 
 	j, _ := json.MarshalIndent(&payload, "", "\t")
-	if err := emitter.Push(string(j), "log.INFO"); err != nil {
+	if err := emitter.Push(string(j), "log."+severity); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// RPCPayload is the payload of RPCServer.LogInfo of the logger. Attributes
+// are sent as JSON, since gob can't encode map[string]any values of types
+// it doesn't have registered, such as the []any of JSON arrays. UserID is
+// the authenticated caller, like the X-User-ID header of the HTTP relays.
 type RPCPayload struct {
-	Name string
-	Data string
+	Name           string
+	Data           string
+	Severity       string
+	Source         string
+	Timestamp      time.Time
+	TraceID        string
+	AttributesJSON string
+	UserID         int
 }
 
-func (s *Service) logItemViaRPC(w http.ResponseWriter, lp LogPayload) {
+func (s *Service) logItemViaRPC(ctx context.Context, w http.ResponseWriter, lp LogPayload) {
 	client, err := rpc.Dial(
 		"tcp",
 		fmt.Sprintf("logger:%s", os.Getenv("RPC_PORT")),
@@ -261,8 +371,25 @@ func (s *Service) logItemViaRPC(w http.ResponseWriter, lp LogPayload) {
 	}
 
 	rpcPayload := &RPCPayload{
-		Name: lp.Name,
-		Data: lp.Data,
+		Name:     lp.Name,
+		Data:     lp.Data,
+		Severity: lp.Severity,
+		Source:   lp.Source,
+		TraceID:  lp.TraceID,
+	}
+	if userID, ok := userIDFromContext(ctx); ok {
+		rpcPayload.UserID = userID
+	}
+	if lp.Timestamp != nil {
+		rpcPayload.Timestamp = *lp.Timestamp
+	}
+	if len(lp.Attributes) > 0 {
+		attributes, err := json.Marshal(lp.Attributes)
+		if err != nil {
+			_ = s.errorJSON(w, err)
+			return
+		}
+		rpcPayload.AttributesJSON = string(attributes)
 	}
 
 	var result string
@@ -303,11 +430,27 @@ func (s *Service) LogViaGRPC(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
+	entry := &logs.Log{
+		Name:     reqPayload.Log.Name,
+		Data:     reqPayload.Log.Data,
+		Severity: reqPayload.Log.Severity,
+		Source:   reqPayload.Log.Source,
+		TraceId:  reqPayload.Log.TraceID,
+	}
+	if reqPayload.Log.Timestamp != nil {
+		entry.Timestamp = timestamppb.New(*reqPayload.Log.Timestamp)
+	}
+	if len(reqPayload.Log.Attributes) > 0 {
+		attributes, err := structpb.NewStruct(reqPayload.Log.Attributes)
+		if err != nil {
+			_ = s.errorJSON(w, err)
+			return
+		}
+		entry.Attributes = attributes.GetFields()
+	}
+
 	if _, err = client.WriteLog(ctx, &logs.LogRequest{
-		LogEntry: &logs.Log{
-			Name: reqPayload.Log.Name,
-			Data: reqPayload.Log.Data,
-		},
+		LogEntry: entry,
 	}); err != nil {
 		_ = s.errorJSON(w, err)
 		return
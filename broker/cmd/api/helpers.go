@@ -3,8 +3,11 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strings"
 )
 
 type jsonResponse struct {
@@ -68,3 +71,85 @@ func (s *Service) errorJSON(w http.ResponseWriter, err error, statuses ...int) e
 
 	return s.writeJSON(w, statusCode, payload)
 }
+
+// clientIP gets IP address of the client which made the request. When the
+// request comes from a trusted proxy, the client is the right-most address
+// of X-Forwarded-For which isn't a trusted proxy itself; the addresses left
+// of it are set by the client, and can't be trusted.
+func (s *Service) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !isTrustedProxy(s.TrustedProxies, ip) {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(s.TrustedProxies, hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// remoteIP gets IP address of the peer which made the request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseTrustedProxies reads the comma separated IP addresses and CIDR
+// networks of trusted proxies.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an IP address or network", item)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP address or network", item)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// isTrustedProxy returns true if the address is in one of the networks.
+func isTrustedProxy(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// LogQueryPayload selects log entries of the logger. With ID only that entry
+// is returned, otherwise one page of the entries matching the filters.
+type LogQueryPayload struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Query  string `json:"q,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// queryLogs runs the log.query action against the logs API of the logger.
+func (s *Service) queryLogs(ctx context.Context, w http.ResponseWriter, lq LogQueryPayload) {
+	endpoint := logsURL
+	if lq.ID != "" {
+		endpoint += "/" + url.PathEscape(lq.ID)
+	} else {
+		query := url.Values{}
+		for key, value := range map[string]string{
+			"name":   lq.Name,
+			"from":   lq.From,
+			"to":     lq.To,
+			"q":      lq.Query,
+			"cursor": lq.Cursor,
+		} {
+			if value != "" {
+				query.Set(key, value)
+			}
+		}
+		if lq.Limit > 0 {
+			query.Set("limit", strconv.Itoa(lq.Limit))
+		}
+		endpoint += "?" + query.Encode()
+	}
+
+	request, err := newJSONRequest(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	s.forward(w, request, "logger")
+}
+
+// LogStatsPayload selects the log entries the logger counts and how it
+// groups them. GroupBy is a comma separated list of name and severity, and
+// Bucket a duration such as "1m" which groups them by time too.
+type LogStatsPayload struct {
+	Name     string  `json:"name,omitempty"`
+	Severity string  `json:"severity,omitempty"`
+	From     string  `json:"from,omitempty"`
+	To       string  `json:"to,omitempty"`
+	Bucket   string  `json:"bucket,omitempty"`
+	GroupBy  *string `json:"group_by,omitempty"`
+}
+
+// logStats runs the log.stats action against the stats API of the logger.
+func (s *Service) logStats(ctx context.Context, w http.ResponseWriter, ls LogStatsPayload) {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"name":     ls.Name,
+		"severity": ls.Severity,
+		"from":     ls.From,
+		"to":       ls.To,
+		"bucket":   ls.Bucket,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	// an empty group_by counts all the entries together
+	if ls.GroupBy != nil {
+		query.Set("group_by", *ls.GroupBy)
+	}
+
+	request, err := newJSONRequest(ctx, http.MethodGet, logsURL+"/stats?"+query.Encode(), nil)
+	if err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	s.forward(w, request, "logger")
+}
+
+// RetentionPayload holds the retention rules of the logger, which checks them.
+type RetentionPayload struct {
+	Rules json.RawMessage `json:"rules"`
+}
+
+// handleRetentionAction runs one of log.retention* actions against the
+// retention API of the logger.
+func (s *Service) handleRetentionAction(ctx context.Context, w http.ResponseWriter, action string, rp RetentionPayload) {
+	var request *http.Request
+	var err error
+
+	switch action {
+	case "log.retention":
+		request, err = newJSONRequest(ctx, http.MethodGet, retentionURL, nil)
+	case "log.retention.set":
+		request, err = newJSONRequest(ctx, http.MethodPut, retentionURL, rp)
+	case "log.retention.report":
+		request, err = newJSONRequest(ctx, http.MethodGet, retentionURL+"/report", nil)
+	default:
+		err = errors.New("unknown action")
+	}
+	if err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	s.forward(w, request, "logger")
+}
+
+// StreamLogs relays the live log stream of the logger to the caller as
+// Server-Sent Events, filtered by name and severity from the query string.
+// EventSource can't send the Authorization header, so browsers read the
+// stream with fetch.
+func (s *Service) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		_ = s.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	query := url.Values{}
+	for _, key := range []string{"name", "severity"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	// the stream lasts as long as the caller stays connected
+	request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, logsURL+"/stream?"+query.Encode(), nil)
+	if err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		_ = s.errorJSON(w, errors.New("error calling logger service"))
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(response.StatusCode)
+		_, _ = io.Copy(w, response.Body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -17,6 +18,9 @@ var (
 
 type Service struct {
 	Rabbit *amqp.Connection
+	// TrustedProxies are the networks of the reverse proxies in front of
+	// the broker, which pass the address of the client in X-Forwarded-For.
+	TrustedProxies []*net.IPNet
 }
 
 func main() {
@@ -28,8 +32,15 @@ func main() {
 	}
 	defer rabbitConn.Close()
 
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	service := Service{
-		Rabbit: rabbitConn,
+		Rabbit:         rabbitConn,
+		TrustedProxies: trustedProxies,
 	}
 
 	srv := http.Server{
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type contextKey string
+
+const (
+	identityContextKey contextKey = "identity"
+
+	// userIDHeader carries id of the authenticated caller to downstream services.
+	userIDHeader = "X-User-ID"
+)
+
+var (
+	errUnauthorized = errors.New("unauthorized")
+	errForbidden    = errors.New("forbidden")
+)
+
+// accessRule tells who may run an action. Public actions can be run by anyone,
+// the others only by authenticated callers which have the Permission, if set.
+type accessRule struct {
+	Public     bool
+	Permission string
+}
+
+// actionPolicy holds access rules of the actions of HandleSubmission.
+// Actions which are not listed can be run by any authenticated caller.
+var actionPolicy = map[string]accessRule{
+	"auth":                 {Public: true},
+	"auth.2fa":             {Public: true},
+	"signup":               {Public: true},
+	"verify":               {Public: true},
+	"verify.resend":        {Public: true},
+	"password.forgot":      {Public: true},
+	"password.reset":       {Public: true},
+	"log":                  {Permission: "logs:write"},
+	"log.query":            {Permission: "logs:read"},
+	"log.stats":            {Permission: "logs:read"},
+	"log.retention":        {Permission: "logs:admin"},
+	"log.retention.set":    {Permission: "logs:admin"},
+	"log.retention.report": {Permission: "logs:admin"},
+	"mail":                 {Permission: "mail:send"},
+	"user.list":            {Permission: "users:read"},
+	"user.get":             {Permission: "users:read"},
+	"user.roles":           {Permission: "users:read"},
+	"user.audit":           {Permission: "users:read"},
+	"user.create":          {Permission: "users:write"},
+	"user.update":          {Permission: "users:write"},
+	"user.password":        {Permission: "users:write"},
+	"user.unlock":          {Permission: "users:write"},
+	"user.roles.set":       {Permission: "roles:write"},
+	"user.delete":          {Permission: "users:delete"},
+}
+
+// identity is the caller's identity returned by the auth service.
+type identity struct {
+	UserID      int      `json:"user_id"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	// APIKeyID is set when the caller authenticated with an API key
+	// instead of a user session.
+	APIKeyID int `json:"api_key_id,omitempty"`
+
+	// authorization is the Authorization header the identity was validated
+	// with, for the services which act on behalf of the caller themselves.
+	authorization string
+}
+
+// can returns true if the caller has the permission.
+func (id *identity) can(permission string) bool {
+	for _, p := range id.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks the caller may run an action with the access rule.
+// The caller is nil if the request isn't authenticated.
+func authorize(id *identity, rule accessRule) error {
+	if rule.Public {
+		return nil
+	}
+	if id == nil {
+		return errUnauthorized
+	}
+	if rule.Permission != "" && !id.can(rule.Permission) {
+		return errForbidden
+	}
+	return nil
+}
+
+// requireAuth rejects requests without a valid access token and puts
+// identity of the authenticated user into the request context.
+func (s *Service) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := s.validateToken(r)
+		if err != nil {
+			_ = s.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), identityContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requirePermission rejects requests of authenticated callers which don't
+// have the permission. It has to run after requireAuth.
+func (s *Service) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := identityFromContext(r.Context())
+			if err := authorize(id, accessRule{Permission: permission}); err != nil {
+				s.accessDeniedJSON(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireAuthForAction applies policy to the action in the request body.
+// Requests running public actions pass through, the others have to be
+// authenticated the same way as in requireAuth and have the permission
+// the action requires.
+func (s *Service) requireAuthForAction(policy map[string]accessRule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			action, err := peekAction(w, r)
+			if err != nil {
+				_ = s.errorJSON(w, err)
+				return
+			}
+
+			rule := policy[action]
+			if rule.Public {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			s.requireAuth(s.requirePermission(rule.Permission)(next)).ServeHTTP(w, r)
+		})
+	}
+}
+
+// accessDeniedJSON writes error of authorize with the matching status code.
+func (s *Service) accessDeniedJSON(w http.ResponseWriter, err error) {
+	if errors.Is(err, errForbidden) {
+		_ = s.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+	_ = s.errorJSON(w, err, http.StatusUnauthorized)
+}
+
+// validateToken asks the auth service who owns the access token or the API
+// key from the Authorization header of the request, sent either as
+// "Bearer <token>" or "ApiKey <key>".
+func (s *Service) validateToken(r *http.Request) (*identity, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, errUnauthorized
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, validateURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", authHeader)
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, errors.New("error calling auth service")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errUnauthorized
+	}
+
+	var jsonFromService struct {
+		Error bool     `json:"error"`
+		Data  identity `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&jsonFromService); err != nil {
+		return nil, err
+	}
+
+	if jsonFromService.Error {
+		return nil, errUnauthorized
+	}
+
+	id := jsonFromService.Data
+	id.authorization = authHeader
+
+	return &id, nil
+}
+
+// peekAction reads action from the JSON body and puts the body back,
+// so it can be read again by the next handler.
+func peekAction(w http.ResponseWriter, r *http.Request) (string, error) {
+	maxLength := 1048576 // 1 MB
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxLength)))
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", err
+	}
+
+	return payload.Action, nil
+}
+
+// identityFromContext gets identity of the authenticated caller from the context.
+func identityFromContext(ctx context.Context) (*identity, bool) {
+	id, ok := ctx.Value(identityContextKey).(*identity)
+	return id, ok
+}
+
+// userIDFromContext gets id of the authenticated user from the context.
+func userIDFromContext(ctx context.Context) (int, bool) {
+	id, ok := identityFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return id.UserID, true
+}
+
+// setAuthorizationHeader passes the Authorization header of the
+// authenticated caller, if any, to the request going to a downstream service.
+func setAuthorizationHeader(ctx context.Context, request *http.Request) {
+	if id, ok := identityFromContext(ctx); ok && id.authorization != "" {
+		request.Header.Set("Authorization", id.authorization)
+	}
+}
+
+// setUserIDHeader passes id of the authenticated user, if any,
+// to the request going to a downstream service.
+func setUserIDHeader(ctx context.Context, request *http.Request) {
+	if userID, ok := userIDFromContext(ctx); ok {
+		request.Header.Set(userIDHeader, strconv.Itoa(userID))
+	}
+}
@@ -26,8 +26,9 @@ func (s *Service) router() http.Handler {
 
 	// Set other endpoints.
 	mux.Post("/", s.Broker)
-	mux.Post("/handle", s.HandleSubmission)
-	mux.Post("/log-grpc", s.LogViaGRPC)
+	mux.With(s.requireAuthForAction(actionPolicy)).Post("/handle", s.HandleSubmission)
+	mux.With(s.requireAuth, s.requirePermission("logs:write")).Post("/log-grpc", s.LogViaGRPC)
+	mux.With(s.requireAuth, s.requirePermission("logs:read")).Get("/logs/stream", s.StreamLogs)
 
 	return mux
 }
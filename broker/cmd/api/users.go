@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type UserPayload struct {
+	ID        int      `json:"id,omitempty"`
+	Email     *string  `json:"email,omitempty"`
+	FirstName *string  `json:"first_name,omitempty"`
+	LastName  *string  `json:"last_name,omitempty"`
+	Password  string   `json:"password,omitempty"`
+	Active    *bool    `json:"active,omitempty"`
+	Page      int      `json:"page,omitempty"`
+	PageSize  int      `json:"page_size,omitempty"`
+	Token     string   `json:"token,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// handleUserAction runs one of user.* actions against the users API of the auth service.
+func (s *Service) handleUserAction(ctx context.Context, w http.ResponseWriter, action string, up UserPayload) {
+	userURL := fmt.Sprintf("%s/%d", usersURL, up.ID)
+
+	if action != "user.list" && action != "user.create" && up.ID < 1 {
+		_ = s.errorJSON(w, errors.New("user id must be provided"))
+		return
+	}
+
+	switch action {
+	case "user.list":
+		query := url.Values{}
+		if up.Page > 0 {
+			query.Set("page", strconv.Itoa(up.Page))
+		}
+		if up.PageSize > 0 {
+			query.Set("page_size", strconv.Itoa(up.PageSize))
+		}
+		s.forwardToAuth(ctx, w, http.MethodGet, usersURL+"?"+query.Encode(), nil)
+	case "user.get":
+		s.forwardToAuth(ctx, w, http.MethodGet, userURL, nil)
+	case "user.create":
+		s.forwardToAuth(ctx, w, http.MethodPost, usersURL, UserPayload{
+			Email:     up.Email,
+			FirstName: up.FirstName,
+			LastName:  up.LastName,
+			Password:  up.Password,
+			Active:    up.Active,
+		})
+	case "user.update":
+		s.forwardToAuth(ctx, w, http.MethodPut, userURL, UserPayload{
+			Email:     up.Email,
+			FirstName: up.FirstName,
+			LastName:  up.LastName,
+			Active:    up.Active,
+		})
+	case "user.delete":
+		s.forwardToAuth(ctx, w, http.MethodDelete, userURL, nil)
+	case "user.password":
+		s.forwardToAuth(ctx, w, http.MethodPost, userURL+"/password", UserPayload{
+			Password: up.Password,
+		})
+	case "user.unlock":
+		s.forwardToAuth(ctx, w, http.MethodPost, userURL+"/unlock", nil)
+	case "user.roles":
+		s.forwardToAuth(ctx, w, http.MethodGet, userURL+"/roles", nil)
+	case "user.audit":
+		query := url.Values{}
+		if up.Page > 0 {
+			query.Set("page", strconv.Itoa(up.Page))
+		}
+		if up.PageSize > 0 {
+			query.Set("page_size", strconv.Itoa(up.PageSize))
+		}
+		s.forwardToAuth(ctx, w, http.MethodGet, userURL+"/audit?"+query.Encode(), nil)
+	case "user.roles.set":
+		roles := up.Roles
+		if roles == nil {
+			roles = []string{}
+		}
+		s.forwardToAuth(ctx, w, http.MethodPut, userURL+"/roles", struct {
+			Roles []string `json:"roles"`
+		}{Roles: roles})
+	default:
+		_ = s.errorJSON(w, errors.New("unknown action"))
+	}
+}
+
+// handleAccountAction runs one of the public account actions, such as
+// signing up and verifying the email address, against the auth service.
+func (s *Service) handleAccountAction(ctx context.Context, w http.ResponseWriter, action string, up UserPayload) {
+	switch action {
+	case "signup":
+		s.forwardToAuth(ctx, w, http.MethodPost, signupURL, UserPayload{
+			Email:     up.Email,
+			FirstName: up.FirstName,
+			LastName:  up.LastName,
+			Password:  up.Password,
+		})
+	case "verify":
+		s.forwardToAuth(ctx, w, http.MethodPost, verifyURL, UserPayload{
+			Token: up.Token,
+		})
+	case "verify.resend":
+		s.forwardToAuth(ctx, w, http.MethodPost, verifyURL+"/resend", UserPayload{
+			Email: up.Email,
+		})
+	case "password.forgot":
+		s.forwardToAuth(ctx, w, http.MethodPost, passwordURL+"/forgot", UserPayload{
+			Email: up.Email,
+		})
+	case "password.reset":
+		s.forwardToAuth(ctx, w, http.MethodPost, passwordURL+"/reset", UserPayload{
+			Token:    up.Token,
+			Password: up.Password,
+		})
+	default:
+		_ = s.errorJSON(w, errors.New("unknown action"))
+	}
+}
+
+// TwoFactorPayload holds the codes of the 2fa.* actions.
+type TwoFactorPayload struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+// handleTwoFactorAction runs one of 2fa.* actions, which manage two-factor
+// authentication of the caller, against the auth service.
+func (s *Service) handleTwoFactorAction(ctx context.Context, w http.ResponseWriter, action string, tp TwoFactorPayload) {
+	switch action {
+	case "2fa.enroll":
+		s.forwardToAuth(ctx, w, http.MethodPost, twoFactorURL+"/totp/enroll", nil)
+	case "2fa.confirm":
+		s.forwardToAuth(ctx, w, http.MethodPost, twoFactorURL+"/totp/confirm", TwoFactorPayload{
+			Code: tp.Code,
+		})
+	case "2fa.disable":
+		s.forwardToAuth(ctx, w, http.MethodPost, twoFactorURL+"/totp/disable", tp)
+	case "2fa.recovery":
+		s.forwardToAuth(ctx, w, http.MethodPost, twoFactorURL+"/recovery-codes", TwoFactorPayload{
+			Code: tp.Code,
+		})
+	default:
+		_ = s.errorJSON(w, errors.New("unknown action"))
+	}
+}
+
+// APIKeyPayload holds the API key of the apikey.* actions.
+type APIKeyPayload struct {
+	ID        int      `json:"id,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	ExpiresIn string   `json:"expires_in,omitempty"`
+}
+
+// handleAPIKeyAction runs one of apikey.* actions, which manage API keys
+// of the caller, against the auth service.
+func (s *Service) handleAPIKeyAction(ctx context.Context, w http.ResponseWriter, action string, kp APIKeyPayload) {
+	switch action {
+	case "apikey.list":
+		s.forwardToAuth(ctx, w, http.MethodGet, apiKeysURL, nil)
+	case "apikey.create":
+		s.forwardToAuth(ctx, w, http.MethodPost, apiKeysURL, APIKeyPayload{
+			Name:      kp.Name,
+			Scopes:    kp.Scopes,
+			ExpiresIn: kp.ExpiresIn,
+		})
+	case "apikey.revoke":
+		if kp.ID < 1 {
+			_ = s.errorJSON(w, errors.New("API key id must be provided"))
+			return
+		}
+		s.forwardToAuth(ctx, w, http.MethodDelete, fmt.Sprintf("%s/%d", apiKeysURL, kp.ID), nil)
+	default:
+		_ = s.errorJSON(w, errors.New("unknown action"))
+	}
+}
+
+// forwardToAuth sends payload, if any, to the auth service and writes
+// its status code and JSON response back unchanged.
+func (s *Service) forwardToAuth(ctx context.Context, w http.ResponseWriter, method, endpoint string, payload any) {
+	request, err := newJSONRequest(ctx, method, endpoint, payload)
+	if err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+	setAuthorizationHeader(ctx, request)
+
+	s.forward(w, request, "auth")
+}
+
+// newJSONRequest creates request to a downstream service with payload,
+// if any, as JSON body, on behalf of the caller in ctx.
+func newJSONRequest(ctx context.Context, method, endpoint string, payload any) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.MarshalIndent(payload, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	setUserIDHeader(ctx, request)
+
+	return request, nil
+}
+
+// forward sends request to the named service and writes its status code
+// and JSON response back unchanged.
+func (s *Service) forward(w http.ResponseWriter, request *http.Request, service string) {
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		_ = s.errorJSON(w, fmt.Errorf("error calling %s service", service))
+		return
+	}
+	defer response.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.StatusCode)
+	_, _ = io.Copy(w, response.Body)
+}
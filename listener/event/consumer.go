@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -35,9 +37,16 @@ func (c *Consumer) setup() error {
 	return declareExchange(channel)
 }
 
+// Payload is the log entry published to logs_topic. Only name and data
+// are required; Severity, if missing, is taken from the routing key.
 type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Severity   string         `json:"severity,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	Timestamp  *time.Time     `json:"timestamp,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
 func (c *Consumer) Listen(topics []string) error {
@@ -83,6 +92,11 @@ func (c *Consumer) Listen(topics []string) error {
 			var payload Payload
 			_ = json.Unmarshal(d.Body, &payload)
 
+			if payload.Severity == "" {
+				// routing keys are log.<SEVERITY>
+				payload.Severity = strings.TrimPrefix(d.RoutingKey, "log.")
+			}
+
 			go handlePayload(payload)
 		}
 	}()
@@ -29,7 +29,7 @@ func main() {
 	}
 
 	// watch the queue and consume events
-	topics := []string{"log.INFO", "log.WARNING", "log.ERROR"}
+	topics := []string{"log.DEBUG", "log.INFO", "log.WARNING", "log.ERROR"}
 	if err := consumer.Listen(topics); err != nil {
 		log.Println(err)
 	}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"logger/data"
+)
+
+const (
+	mailURL = "http://mail/send"
+
+	// alertQueue is how many alerts may wait to be mailed. Alerts beyond
+	// it are dropped, so a slow mail service never holds up the writes.
+	alertQueue  = 100
+	mailTimeout = 10 * time.Second
+)
+
+// mailMessage is the payload of the /send endpoint of the mail service.
+// An empty From is the default address of the mail service.
+type mailMessage struct {
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+// loadAlertRules reads the JSON array of alert rules from the file at path
+// and validates them.
+func loadAlertRules(path string) ([]data.AlertRule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []data.AlertRule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("reading alert rules: %w", err)
+	}
+	if err := data.ValidateAlertRules(rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// watchAlerts evaluates the rules against every stored entry, and mails
+// the alerts they fire.
+func (s *Service) watchAlerts(rules []data.AlertRule) {
+	alerter := data.NewAlerter(rules)
+	alerts := make(chan data.Alert, alertQueue)
+
+	s.Models.Feed.Observe(func(entry *data.LogEntry) {
+		for _, alert := range alerter.Evaluate(entry) {
+			select {
+			case alerts <- alert:
+			default:
+				log.Printf("Dropping alert %s, too many are waiting\n", alert.Rule.ID)
+			}
+		}
+	})
+
+	go func() {
+		for alert := range alerts {
+			if err := sendAlert(alert); err != nil {
+				log.Printf("Mailing alert %s error: %v\n", alert.Rule.ID, err)
+			}
+		}
+	}()
+}
+
+// sendAlert mails the alert through the mail service.
+func sendAlert(alert data.Alert) error {
+	severity := alert.Entry.Severity
+	if alert.Rule.Severity != "" {
+		severity = alert.Rule.Severity
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "Alert rule %s fired for %s logs of %s.\n\n", alert.Rule.ID, severity, alert.Entry.Name)
+	if alert.Rule.Threshold > 1 {
+		fmt.Fprintf(&message, "%d logs arrived within %s.\n", alert.Count, time.Duration(alert.Rule.Window))
+	}
+	if alert.Suppressed > 0 {
+		fmt.Fprintf(&message, "%d more matched since the last alert.\n", alert.Suppressed)
+	}
+	fmt.Fprintf(&message, "\nLast log, stored at %s", alert.Entry.CreatedAt.Format(time.RFC3339))
+	if alert.Entry.Source != "" {
+		fmt.Fprintf(&message, " from %s", alert.Entry.Source)
+	}
+	fmt.Fprintf(&message, ":\n%s\n", alert.Entry.Data)
+
+	payload, err := json.Marshal(mailMessage{
+		To:      alert.Rule.To,
+		Subject: fmt.Sprintf("[%s] %s: %s", severity, alert.Rule.ID, alert.Entry.Name),
+		Message: message.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, mailURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("mail service answered %s", response.Status)
+	}
+
+	return nil
+}
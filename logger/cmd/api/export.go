@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"logger/data"
+)
+
+// archiveDayLayout formats the day in the names of archives.
+const archiveDayLayout = "2006-01-02"
+
+// errFoundOldest stops the export which looks for the oldest entry.
+var errFoundOldest = errors.New("found the oldest log")
+
+// ExportLogs streams the log entries as a file in format ndjson, which is
+// the default, or csv, oldest first. The query string filters them by name,
+// and from and to as RFC 3339 times, and gzip=true compresses the file.
+func (s *Service) ExportLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = data.FormatNDJSON
+	}
+	if err := data.ValidateFormat(format); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	compress := false
+	if value := query.Get("gzip"); value != "" {
+		var err error
+		if compress, err = strconv.ParseBool(value); err != nil {
+			_ = s.errorJSON(w, errors.New("gzip must be true or false"))
+			return
+		}
+	}
+
+	filter := data.LogFilter{Name: query.Get("name")}
+
+	var err error
+	if filter.From, err = readTimeQuery(query.Get("from")); err != nil {
+		_ = s.errorJSON(w, errors.New("from must be an RFC 3339 time"))
+		return
+	}
+	if filter.To, err = readTimeQuery(query.Get("to")); err != nil {
+		_ = s.errorJSON(w, errors.New("to must be an RFC 3339 time"))
+		return
+	}
+
+	filename := "logs." + format
+	contentType := "application/x-ndjson"
+	if format == data.FormatCSV {
+		contentType = "text/csv"
+	}
+	if compress {
+		filename += ".gz"
+		contentType = "application/gzip"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	// the status is sent already, so a failure can only cut the file short
+	count, err := s.Models.Export(w, format, compress, filter)
+	if err != nil {
+		log.Printf("Exporting logs error after %d entries: %v\n", count, err)
+	}
+}
+
+// archiveLogs writes the entries of each day, once it's over, to a gzipped
+// file named after the day in dir, such as logs-2006-01-02.ndjson.gz. Every
+// past day with stored entries is archived, so days missed while the logger
+// was down or archiving failed are caught up; days which already have their
+// archive are skipped, so restarts don't write them again.
+func (s *Service) archiveLogs(dir, format string) {
+	for {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		if err := s.archivePastDays(dir, format, today); err != nil {
+			log.Printf("Archiving logs error: %v\n", err)
+		}
+
+		// shortly after the next midnight, when the next day is over
+		next := time.Now().UTC().Truncate(24 * time.Hour).Add(24*time.Hour + time.Minute)
+		time.Sleep(time.Until(next))
+	}
+}
+
+// archivePastDays archives each day with entries, from the one of the oldest
+// stored entry to the one before today.
+func (s *Service) archivePastDays(dir, format string, today time.Time) error {
+	var oldest *data.LogEntry
+	err := s.Models.LogEntry.Export(data.LogFilter{To: today}, func(entry *data.LogEntry) error {
+		oldest = entry
+		return errFoundOldest
+	})
+	if err != nil && !errors.Is(err, errFoundOldest) {
+		return err
+	}
+	if oldest == nil {
+		return nil
+	}
+
+	// days without entries get no archive
+	days, err := s.Models.LogEntry.Stats(data.StatsQuery{
+		From:   oldest.CreatedAt.UTC().Truncate(24 * time.Hour),
+		To:     today,
+		Bucket: 24 * time.Hour,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, stat := range days {
+		if stat.Bucket == nil || stat.Count == 0 {
+			continue
+		}
+		day := stat.Bucket.UTC()
+		if err := s.archiveDay(dir, format, day); err != nil {
+			log.Printf("Archiving logs of %s error: %v\n", day.Format(archiveDayLayout), err)
+		}
+	}
+
+	return nil
+}
+
+// archiveDay writes the archive of the day, unless it exists or the day has
+// no entries.
+func (s *Service) archiveDay(dir, format string, day time.Time) error {
+	path := filepath.Join(dir, fmt.Sprintf("logs-%s.%s.gz", day.Format(archiveDayLayout), format))
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	// written aside and renamed, so a partial archive is never taken as done
+	tmp, err := os.CreateTemp(dir, ".logs-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	count, err := s.Models.Export(tmp, format, true, data.LogFilter{
+		From: day,
+		To:   day.AddDate(0, 0, 1),
+	})
+	if err != nil {
+		return err
+	}
+	// the entries may have been purged since they were counted
+	if count == 0 {
+		return nil
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	log.Printf("Archived %d logs to %s\n", count, path)
+	return nil
+}
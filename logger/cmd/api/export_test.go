@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"logger/data"
+)
+
+func TestArchivePastDaysSkipsDaysWithoutEntries(t *testing.T) {
+	store, err := data.NewFileStore(filepath.Join(t.TempDir(), "logs.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	first := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	_, err = store.InsertMany([]data.LogEntry{
+		{Name: "auth", Data: "signed in", CreatedAt: first},
+		{Name: "auth", Data: "signed out", CreatedAt: first.AddDate(0, 0, 2)},
+		{Name: "auth", Data: "signed in", CreatedAt: first.AddDate(0, 0, 4)},
+	})
+	if err != nil {
+		t.Fatalf("inserting entries: %v", err)
+	}
+
+	s := &Service{Models: data.New(store)}
+	dir := t.TempDir()
+	if err := s.archivePastDays(dir, data.FormatNDJSON, first.AddDate(0, 0, 4).Truncate(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, file := range files {
+		got = append(got, file.Name())
+	}
+
+	want := []string{"logs-2024-03-01.ndjson.gz", "logs-2024-03-03.ndjson.gz"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("archives = %v, want %v", got, want)
+	}
+}
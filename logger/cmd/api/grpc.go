@@ -2,37 +2,42 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"logger/data"
 	"logger/logs"
 	"net"
+	"strings"
 
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type LogServer struct {
 	logs.UnimplementedLogServiceServer
 	Models data.Models
+	// Done is closed on shutdown, which ends the TailLogs streams.
+	Done <-chan struct{}
 }
 
 func (ls *LogServer) WriteLog(
 	ctx context.Context,
 	req *logs.LogRequest,
 ) (*logs.LogResponse, error) {
-	input := req.GetLogEntry()
-
 	// write the log
-	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
-	}
+	logEntry := fromProtoLog(req.GetLogEntry())
 
-	if err := ls.Models.LogEntry.Insert(logEntry); err != nil {
+	if _, err := ls.Models.LogEntry.Insert(logEntry); err != nil {
 		resp := &logs.LogResponse{
 			Result: "failed",
 		}
-		return resp, err
+		return resp, insertError(err)
 	}
 
 	// return response
@@ -41,21 +46,232 @@ func (ls *LogServer) WriteLog(
 	}, nil
 }
 
-func (s *Service) gRPCListen() {
+// writeLogsChunk is how many of the streamed entries WriteLogs stores at once.
+const writeLogsChunk = 100
+
+// WriteLogs stores the entries streamed by the client, a chunk at a time.
+// The entries before a failed one stay stored, and their count is returned
+// with the error, so the client resends only the rest.
+func (ls *LogServer) WriteLogs(stream logs.LogService_WriteLogsServer) error {
+	var count int64
+	chunk := make([]data.LogEntry, 0, writeLogsChunk)
+
+	store := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		stored, err := ls.Models.LogEntry.InsertMany(chunk)
+		if err != nil {
+			count += int64(len(stored))
+			return status.Errorf(status.Code(insertError(err)), "logged %d entries: %v", count, err)
+		}
+		count += int64(len(chunk))
+		chunk = chunk[:0]
+		return nil
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			if err := store(); err != nil {
+				return err
+			}
+			return stream.SendAndClose(&logs.WriteLogsResponse{
+				Result: "logged!",
+				Count:  count,
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		chunk = append(chunk, fromProtoLog(req.GetLogEntry()))
+		if len(chunk) == writeLogsChunk {
+			if err := store(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// QueryLogs returns one page of the entries matching the request, newest first.
+func (ls *LogServer) QueryLogs(
+	ctx context.Context,
+	req *logs.QueryLogsRequest,
+) (*logs.QueryLogsResponse, error) {
+	filter := data.LogFilter{
+		Name:   req.GetName(),
+		Text:   req.GetQuery(),
+		Cursor: req.GetCursor(),
+		Limit:  int(req.GetLimit()),
+	}
+	if req.GetFrom() != nil {
+		filter.From = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		filter.To = req.GetTo().AsTime()
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = defaultLogsLimit
+	}
+	if filter.Limit < 1 || filter.Limit > maxLogsLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxLogsLimit)
+	}
+
+	entries, next, err := ls.Models.LogEntry.Find(filter)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &logs.QueryLogsResponse{
+		Logs:       make([]*logs.Log, 0, len(entries)),
+		NextCursor: next,
+	}
+	for _, entry := range entries {
+		resp.Logs = append(resp.Logs, toProtoLog(entry))
+	}
+
+	return resp, nil
+}
+
+// TailLogs streams the entries as they are written, until the client cancels.
+// The request filters them by name and severity, like StreamLogs does.
+// Entries are sent only while the client keeps up, see data.LogFeed.
+func (ls *LogServer) TailLogs(req *logs.TailLogsRequest, stream logs.LogService_TailLogsServer) error {
+	severity := strings.ToUpper(req.GetSeverity())
+	switch severity {
+	case "", data.SeverityDebug, data.SeverityInfo, data.SeverityWarning, data.SeverityError:
+	default:
+		return status.Error(codes.InvalidArgument, data.ErrInvalidSeverity.Error())
+	}
+
+	entries, unsubscribe := ls.Models.Feed.Subscribe(req.GetName(), severity)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ls.Done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case entry := <-entries:
+			if err := stream.Send(toProtoLog(entry)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetLog returns the entry with the id.
+func (ls *LogServer) GetLog(
+	ctx context.Context,
+	req *logs.GetLogRequest,
+) (*logs.Log, error) {
+	entry, err := ls.Models.LogEntry.GetOne(req.GetId())
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return toProtoLog(entry), nil
+}
+
+// toProtoLog converts the stored entry to its protobuf message.
+func toProtoLog(entry *data.LogEntry) *logs.Log {
+	l := &logs.Log{
+		Id:        entry.ID,
+		Name:      entry.Name,
+		Data:      entry.Data,
+		CreatedAt: timestamppb.New(entry.CreatedAt),
+		Severity:  entry.Severity,
+		Source:    entry.Source,
+		TraceId:   entry.TraceID,
+	}
+	if entry.Timestamp != nil {
+		l.Timestamp = timestamppb.New(*entry.Timestamp)
+	}
+	if len(entry.Attributes) > 0 {
+		l.Attributes = make(map[string]*structpb.Value, len(entry.Attributes))
+		for key, value := range entry.Attributes {
+			v, err := structpb.NewValue(value)
+			if err != nil {
+				continue
+			}
+			l.Attributes[key] = v
+		}
+	}
+
+	return l
+}
+
+// fromProtoLog converts the message sent by the producer to the entry to
+// store. Fields set by the logger are ignored.
+func fromProtoLog(l *logs.Log) data.LogEntry {
+	entry := data.LogEntry{
+		Name:     l.GetName(),
+		Data:     l.GetData(),
+		Severity: l.GetSeverity(),
+		Source:   l.GetSource(),
+		TraceID:  l.GetTraceId(),
+	}
+	if l.GetTimestamp() != nil {
+		timestamp := l.GetTimestamp().AsTime()
+		entry.Timestamp = &timestamp
+	}
+	if len(l.GetAttributes()) > 0 {
+		entry.Attributes = make(map[string]any, len(l.GetAttributes()))
+		for key, value := range l.GetAttributes() {
+			entry.Attributes[key] = value.AsInterface()
+		}
+	}
+
+	return entry
+}
+
+// insertError converts the error of inserting an entry to its gRPC status.
+func insertError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrInvalidSeverity) || errors.Is(err, data.ErrInvalidAttribute):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, data.ErrBufferFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, data.ErrWriterClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// gRPCListen starts serving the log and OTLP services. The returned server
+// stops them, and closing done ends the TailLogs streams.
+func (s *Service) gRPCListen(done <-chan struct{}) (*grpc.Server, error) {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", grpcPort))
 	if err != nil {
-		log.Fatalf("Failed to listen for gRPC: %v", err)
+		return nil, err
 	}
 
 	server := grpc.NewServer()
 
 	logs.RegisterLogServiceServer(server, &LogServer{
 		Models: s.Models,
+		Done:   done,
+	})
+	collogs.RegisterLogsServiceServer(server, &OTLPLogServer{
+		Models: s.Models,
 	})
 
 	log.Printf("gRPC server started on port %s", grpcPort)
 
-	if err := server.Serve(lis); err != nil {
-		log.Fatalf("Failed to listen for gRPC: %v", err)
-	}
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			log.Fatalf("Failed to listen for gRPC: %v", err)
+		}
+	}()
+
+	return server, nil
 }
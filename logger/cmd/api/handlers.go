@@ -1,14 +1,48 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 
 	"logger/data"
 )
 
+// userIDHeader carries id of the user the broker authenticated.
+const userIDHeader = "X-User-ID"
+
+// userIDAttribute is the attribute of the user who wrote the entry through
+// the broker.
+const userIDAttribute = "user_id"
+
+// withUserID sets the user_id attribute of the authenticated user, over
+// any the producer set, if userID is one.
+func withUserID(attributes map[string]any, userID int) map[string]any {
+	if userID <= 0 {
+		return attributes
+	}
+	if attributes == nil {
+		attributes = map[string]any{}
+	}
+	attributes[userIDAttribute] = userID
+	return attributes
+}
+
+// JSONPayload is a log entry sent over HTTP. Only name and data are
+// required, as producers of the old shape send just them.
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Severity   string         `json:"severity,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	Timestamp  *time.Time     `json:"timestamp,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
 func (s *Service) WriteLog(w http.ResponseWriter, r *http.Request) {
@@ -18,11 +52,27 @@ func (s *Service) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	// insert data
 	logEntry := data.LogEntry{
-		Name: reqPayload.Name,
-		Data: reqPayload.Data,
+		Name:       reqPayload.Name,
+		Data:       reqPayload.Data,
+		Severity:   reqPayload.Severity,
+		Source:     reqPayload.Source,
+		Timestamp:  reqPayload.Timestamp,
+		TraceID:    reqPayload.TraceID,
+		Attributes: reqPayload.Attributes,
 	}
-	if err := s.Models.LogEntry.Insert(logEntry); err != nil {
-		_ = s.errorJSON(w, err)
+	if userID, err := strconv.Atoi(r.Header.Get(userIDHeader)); err == nil {
+		logEntry.Attributes = withUserID(logEntry.Attributes, userID)
+	}
+	if _, err := s.Models.LogEntry.Insert(logEntry); err != nil {
+		switch {
+		case errors.Is(err, data.ErrBufferFull):
+			w.Header().Set("Retry-After", "1")
+			_ = s.errorJSON(w, err, http.StatusServiceUnavailable)
+		case errors.Is(err, data.ErrWriterClosed):
+			_ = s.errorJSON(w, err, http.StatusServiceUnavailable)
+		default:
+			_ = s.errorJSON(w, err)
+		}
 		return
 	}
 
@@ -32,3 +82,155 @@ func (s *Service) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	_ = s.writeJSON(w, http.StatusAccepted, resp)
 }
+
+const (
+	defaultLogsLimit = 50
+	maxLogsLimit     = 500
+)
+
+type logsPage struct {
+	Logs       []*data.LogEntry `json:"logs"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// GetLogs returns one page of log entries, newest first, filtered by the
+// query string: name, from and to as RFC 3339 times, q matched against
+// the data, and limit. The next page is read with the returned cursor.
+func (s *Service) GetLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := data.LogFilter{
+		Name:   query.Get("name"),
+		Text:   query.Get("q"),
+		Cursor: query.Get("cursor"),
+		Limit:  defaultLogsLimit,
+	}
+
+	var err error
+	if filter.From, err = readTimeQuery(query.Get("from")); err != nil {
+		_ = s.errorJSON(w, errors.New("from must be an RFC 3339 time"))
+		return
+	}
+	if filter.To, err = readTimeQuery(query.Get("to")); err != nil {
+		_ = s.errorJSON(w, errors.New("to must be an RFC 3339 time"))
+		return
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxLogsLimit {
+			_ = s.errorJSON(w, fmt.Errorf("limit must be between 1 and %d", maxLogsLimit))
+			return
+		}
+	}
+
+	entries, next, err := s.Models.LogEntry.Find(filter)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCursor) {
+			_ = s.errorJSON(w, err)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("%d logs", len(entries)),
+		Data: logsPage{
+			Logs:       entries,
+			NextCursor: next,
+		},
+	})
+}
+
+// GetStats returns the counts of the log entries, filtered by name,
+// severity, and from and to as RFC 3339 times, from the query string.
+// group_by lists the fields the counts are grouped by, name and severity
+// by default, and bucket, a duration such as 1m, groups them by time too.
+func (s *Service) GetStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	stats := data.StatsQuery{
+		Name:       query.Get("name"),
+		Severity:   query.Get("severity"),
+		ByName:     true,
+		BySeverity: true,
+	}
+
+	var err error
+	if stats.From, err = readTimeQuery(query.Get("from")); err != nil {
+		_ = s.errorJSON(w, errors.New("from must be an RFC 3339 time"))
+		return
+	}
+	if stats.To, err = readTimeQuery(query.Get("to")); err != nil {
+		_ = s.errorJSON(w, errors.New("to must be an RFC 3339 time"))
+		return
+	}
+
+	if bucket := query.Get("bucket"); bucket != "" {
+		if stats.Bucket, err = time.ParseDuration(bucket); err != nil {
+			_ = s.errorJSON(w, errors.New(`bucket must be a duration such as "1m"`))
+			return
+		}
+	}
+
+	if query.Has("group_by") {
+		stats.ByName, stats.BySeverity = false, false
+		for _, field := range strings.Split(query.Get("group_by"), ",") {
+			switch strings.TrimSpace(field) {
+			case "":
+			case "name":
+				stats.ByName = true
+			case "severity":
+				stats.BySeverity = true
+			default:
+				_ = s.errorJSON(w, fmt.Errorf("can't group by %q, use name and severity", field))
+				return
+			}
+		}
+	}
+
+	if err := stats.Validate(); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	counts, err := s.Models.LogEntry.Stats(stats)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("%d groups", len(counts)),
+		Data:    counts,
+	})
+}
+
+// GetLog returns the log entry with the id from the URL.
+func (s *Service) GetLog(w http.ResponseWriter, r *http.Request) {
+	entry, err := s.Models.LogEntry.GetOne(chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			_ = s.errorJSON(w, err, http.StatusNotFound)
+		} else {
+			_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: "log",
+		Data:    entry,
+	})
+}
+
+// readTimeQuery parses the RFC 3339 time of a query string parameter,
+// and returns zero time if it is empty.
+func readTimeQuery(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
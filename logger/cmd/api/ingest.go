@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+
+	"logger/data"
+)
+
+// maxIngesting is how many entries of one ingester may wait for their
+// batch at once.
+const maxIngesting = 1000
+
+// ingester inserts the entries of the protocols which have no way to
+// acknowledge them, such as syslog. Inserts wait for their batch to be
+// stored, so they run aside and reading goes on meanwhile; once
+// maxIngesting of them are waiting, ingest blocks and reading slows down.
+type ingester struct {
+	models data.Models
+	// protocol names the source of the entries in errors
+	protocol string
+	slots    chan struct{}
+}
+
+func newIngester(models data.Models, protocol string) *ingester {
+	return &ingester{
+		models:   models,
+		protocol: protocol,
+		slots:    make(chan struct{}, maxIngesting),
+	}
+}
+
+// ingest inserts the entry without waiting for it to be stored.
+func (in *ingester) ingest(entry data.LogEntry) {
+	in.slots <- struct{}{}
+
+	go func() {
+		defer func() { <-in.slots }()
+
+		if _, err := in.models.LogEntry.Insert(entry); err != nil {
+			log.Printf("Error writing %s log: %v\n", in.protocol, err)
+		}
+	}()
+}
+
+// wait waits until the entries being inserted are stored.
+func (in *ingester) wait() {
+	for i := 0; i < maxIngesting; i++ {
+		in.slots <- struct{}{}
+	}
+	for i := 0; i < maxIngesting; i++ {
+		<-in.slots
+	}
+}
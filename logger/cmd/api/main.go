@@ -2,22 +2,33 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"logger/data"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc"
 )
 
 const (
-	mongoConnTimeout = 15
+	defaultRetentionInterval = time.Hour
+	defaultWriteBatchSize    = 100
+	defaultWriteBatchWait    = 50 * time.Millisecond
+	defaultWriteBuffer       = 1000
+	shutdownTimeout          = 30 * time.Second
 )
 
 var (
@@ -26,8 +37,6 @@ var (
 	rpcPort  = os.Getenv("RPC_PORT")
 	mongoURI = os.Getenv("MONGO_URI")
 	grpcPort = os.Getenv("GRPC_PORT")
-
-	client *mongo.Client
 )
 
 type Service struct {
@@ -35,71 +44,232 @@ type Service struct {
 }
 
 func main() {
-	// connect to mongo
-	mongoClient, err := connectToMongoDB()
+	// connect to the configured storage
+	store, err := openStore(os.Getenv("LOG_STORE"))
 	if err != nil {
 		log.Panic(err)
 	}
 
-	client = mongoClient
-
-	// create a context in order to disconnect
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		time.Second*time.Duration(mongoConnTimeout),
+	// buffer the writes and store them in batches
+	store = data.NewBatchWriter(
+		store,
+		intEnv("WRITE_BATCH_SIZE", defaultWriteBatchSize),
+		durationEnv("WRITE_BATCH_WAIT", defaultWriteBatchWait),
+		intEnv("WRITE_BUFFER", defaultWriteBuffer),
 	)
-	defer cancel()
 
-	// close connection
+	// write the buffered entries and close connection
 	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			log.Printf("Mongo client disconnect error: %v\n", err)
-			panic(err)
+		if err := store.Close(); err != nil {
+			log.Printf("Closing log store error: %v\n", err)
 		}
 	}()
 
 	service := Service{
-		Models: data.New(client),
+		Models: data.New(store),
+	}
+
+	// purge logs past their retention
+	go service.purgeLogs(durationEnv("RETENTION_INTERVAL", defaultRetentionInterval))
+
+	// mail alerts of the rules, if a file of them is set
+	if path := os.Getenv("ALERT_RULES"); path != "" {
+		rules, err := loadAlertRules(path)
+		if err != nil {
+			log.Panicf("Malformed ALERT_RULES %q: %v", path, err)
+		}
+		service.watchAlerts(rules)
+	}
+
+	// archive each day of logs, if a directory is set
+	if dir := os.Getenv("ARCHIVE_DIR"); dir != "" {
+		format := os.Getenv("ARCHIVE_FORMAT")
+		if format == "" {
+			format = data.FormatNDJSON
+		}
+		if err := data.ValidateFormat(format); err != nil {
+			log.Panicf("Malformed ARCHIVE_FORMAT %q", format)
+		}
+		go service.archiveLogs(dir, format)
 	}
 
 	// Register the RPC server
-	err = rpc.Register(new(RPCServer))
-	go service.rpcListen()
+	if err := rpc.Register(&RPCServer{Models: service.Models}); err != nil {
+		log.Panicf("Failed to register RPC server: %v", err)
+	}
+	rpcListener, err := service.rpcListen()
+	if err != nil {
+		log.Panicf("Failed to listen for RPC: %v", err)
+	}
 
-	// Start gRPC server
-	go service.gRPCListen()
+	// requests and gRPC calls are canceled on shutdown, which ends the log streams
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+
+	// Start gRPC server, which receives OTLP logs too
+	grpcServer, err := service.gRPCListen(baseCtx.Done())
+	if err != nil {
+		log.Panicf("Failed to listen for gRPC: %v", err)
+	}
+
+	// Start syslog server, if a port is set
+	var syslogSrv *syslogServer
+	if port := os.Getenv("SYSLOG_PORT"); port != "" {
+		syslogSrv, err = service.syslogListen(port)
+		if err != nil {
+			log.Panicf("Failed to listen for syslog: %v", err)
+		}
+	}
 
 	// start web server
 	log.Printf("Starting service on port: %v\n", webPort)
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", webPort),
-		Handler: service.router(),
+		Addr:        fmt.Sprintf(":%s", webPort),
+		Handler:     service.router(),
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
+	srv.RegisterOnShutdown(cancelRequests)
 
-	if err := srv.ListenAndServe(); err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// on shutdown, let the requests and calls in flight finish before the
+	// buffered entries are written by the deferred close
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
 		log.Printf("Serving error: %v\n", err)
-		panic(err)
+	case sig := <-quit:
+		log.Printf("Received %v, shutting down\n", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutting down error: %v\n", err)
+	}
+	cancelRequests()
+
+	if err := rpcListener.Close(); err != nil {
+		log.Printf("Closing RPC listener error: %v\n", err)
+	}
+	stopGRPC(ctx, grpcServer)
+	if syslogSrv != nil {
+		if err := syslogSrv.Close(); err != nil {
+			log.Printf("Closing syslog server error: %v\n", err)
+		}
+	}
+}
+
+// stopGRPC lets the calls in flight finish, but cancels the ones still
+// running when ctx is done.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+		<-stopped
 	}
 }
 
-// rpcListen registers and serve RPC connection.
-func (s *Service) rpcListen() error {
+// rpcListen starts serving RPC connections. They are accepted until the
+// returned listener is closed.
+func (s *Service) rpcListen() (net.Listener, error) {
 	log.Printf("Starting RPC server on %s:%s", rpcHost, rpcPort)
 	listen, err := net.Listen("tcp", fmt.Sprintf("%s:%s", rpcHost, rpcPort))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer listen.Close()
 
+	go serveRPC(listen)
+	return listen, nil
+}
+
+// serveRPC serves the connections until listen is closed.
+func serveRPC(listen net.Listener) {
 	for {
 		rpcConn, err := listen.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Accepting RPC connection error: %v\n", err)
 			continue
 		}
 		go rpc.ServeConn(rpcConn)
 	}
 }
 
+// openStore opens the LogStore of the kind: mongo, which is the default,
+// postgres or file.
+func openStore(kind string) (data.LogStore, error) {
+	switch kind {
+	case "", "mongo":
+		mongoClient, err := connectToMongoDB()
+		if err != nil {
+			return nil, err
+		}
+		return data.NewMongoStore(mongoClient, os.Getenv("MONGO_DBNAME"))
+	case "postgres":
+		db, err := sql.Open("pgx", os.Getenv("POSTGRES_DSN"))
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			return nil, err
+		}
+		log.Println("Connected to postgres!")
+		return data.NewPostgresStore(db)
+	case "file":
+		path := os.Getenv("LOG_FILE")
+		if path == "" {
+			return nil, errors.New("LOG_FILE is not set")
+		}
+		return data.NewFileStore(path)
+	default:
+		return nil, fmt.Errorf("unknown LOG_STORE %q, use mongo, postgres or file", kind)
+	}
+}
+
+// intEnv reads the positive integer in the environment variable key, or
+// returns def if it's not set.
+func intEnv(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Panicf("Malformed %s %q", key, value)
+	}
+	return n
+}
+
+// durationEnv reads the positive duration in the environment variable key,
+// or returns def if it's not set.
+func durationEnv(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Panicf("Malformed %s %q", key, value)
+	}
+	return d
+}
+
 func connectToMongoDB() (*mongo.Client, error) {
 	// create connection options
 	clientOptions := options.Client().ApplyURI(mongoURI)
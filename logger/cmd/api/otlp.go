@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"logger/data"
+
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// otlpName is the name of the entries of resources without service.name.
+const otlpName = "otlp"
+
+// OTLPLogServer receives logs from OpenTelemetry SDKs and collectors over
+// OTLP/gRPC, on the gRPC server of the logger.
+type OTLPLogServer struct {
+	collogs.UnimplementedLogsServiceServer
+	Models data.Models
+}
+
+// Export stores the log records of the request, and answers once all of
+// them are stored, so the exporter retries the request on failure. The
+// records stored before the failure are stored again by the retry, OTLP
+// has no way to retry only the rest.
+func (ls *OTLPLogServer) Export(
+	ctx context.Context,
+	req *collogs.ExportLogsServiceRequest,
+) (*collogs.ExportLogsServiceResponse, error) {
+	var entries []data.LogEntry
+	for _, resourceLogs := range req.GetResourceLogs() {
+		name := otlpName
+		for _, attribute := range resourceLogs.GetResource().GetAttributes() {
+			if attribute.GetKey() == "service.name" && attribute.GetValue().GetStringValue() != "" {
+				name = attribute.GetValue().GetStringValue()
+			}
+		}
+
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				entries = append(entries, fromOTLPRecord(name, scopeLogs.GetScope().GetName(), record))
+			}
+		}
+	}
+
+	if len(entries) > 0 {
+		if _, err := ls.Models.LogEntry.InsertMany(entries); err != nil {
+			// exporters retry on Unavailable, but not on Internal
+			if status.Code(insertError(err)) == codes.Internal {
+				return nil, status.Error(codes.Unavailable, err.Error())
+			}
+			return nil, insertError(err)
+		}
+	}
+
+	return &collogs.ExportLogsServiceResponse{}, nil
+}
+
+// fromOTLPRecord maps the log record of the service to the entry to store.
+// The instrumentation scope is the source, the body is the data, and the
+// attributes of the record are kept, with structured values as JSON.
+func fromOTLPRecord(name, scope string, record *logspb.LogRecord) data.LogEntry {
+	entry := data.LogEntry{
+		Name:     name,
+		Source:   scope,
+		Severity: otlpSeverity(record),
+	}
+
+	if body := record.GetBody(); body != nil {
+		if s, ok := body.GetValue().(*commonpb.AnyValue_StringValue); ok {
+			entry.Data = s.StringValue
+		} else {
+			out, _ := json.Marshal(otlpValue(body))
+			entry.Data = string(out)
+		}
+	}
+
+	timestamp := record.GetTimeUnixNano()
+	if timestamp == 0 {
+		timestamp = record.GetObservedTimeUnixNano()
+	}
+	if timestamp != 0 {
+		t := time.Unix(0, int64(timestamp)).UTC()
+		entry.Timestamp = &t
+	}
+
+	if len(record.GetTraceId()) > 0 {
+		entry.TraceID = hex.EncodeToString(record.GetTraceId())
+	}
+
+	if len(record.GetAttributes()) > 0 || len(record.GetSpanId()) > 0 {
+		entry.Attributes = make(map[string]any, len(record.GetAttributes())+1)
+		for _, attribute := range record.GetAttributes() {
+			value := otlpValue(attribute.GetValue())
+			switch value.(type) {
+			case []any, map[string]any:
+				out, _ := json.Marshal(value)
+				value = string(out)
+			case nil:
+				continue
+			}
+			entry.Attributes[attribute.GetKey()] = value
+		}
+		if len(record.GetSpanId()) > 0 {
+			entry.Attributes["span_id"] = hex.EncodeToString(record.GetSpanId())
+		}
+	}
+
+	return entry
+}
+
+// otlpSeverity maps the severity number of the record, or its text if the
+// number isn't set, to the severities of the entries. TRACE is taken as
+// DEBUG, and FATAL as ERROR.
+func otlpSeverity(record *logspb.LogRecord) string {
+	switch n := record.GetSeverityNumber(); {
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_ERROR:
+		return data.SeverityError
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_WARN:
+		return data.SeverityWarning
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_INFO:
+		return data.SeverityInfo
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_TRACE:
+		return data.SeverityDebug
+	}
+
+	switch text := strings.ToUpper(record.GetSeverityText()); {
+	case strings.HasPrefix(text, "ERR"), strings.HasPrefix(text, "FATAL"), strings.HasPrefix(text, "CRIT"):
+		return data.SeverityError
+	case strings.HasPrefix(text, "WARN"):
+		return data.SeverityWarning
+	case strings.HasPrefix(text, "DEBUG"), strings.HasPrefix(text, "TRACE"):
+		return data.SeverityDebug
+	default:
+		return data.SeverityInfo
+	}
+}
+
+// otlpValue converts the value to its Go equivalent. Bytes are base64.
+func otlpValue(v *commonpb.AnyValue) any {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return value.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return value.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return value.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(value.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]any, 0, len(value.ArrayValue.GetValues()))
+		for _, item := range value.ArrayValue.GetValues() {
+			values = append(values, otlpValue(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		values := make(map[string]any, len(value.KvlistValue.GetValues()))
+		for _, kv := range value.KvlistValue.GetValues() {
+			values[kv.GetKey()] = otlpValue(kv.GetValue())
+		}
+		return values
+	default:
+		return nil
+	}
+}
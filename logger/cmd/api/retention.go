@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"logger/data"
+)
+
+type retentionPayload struct {
+	Rules []data.RetentionRule `json:"rules"`
+}
+
+// GetRetention returns the retention rules.
+func (s *Service) GetRetention(w http.ResponseWriter, _ *http.Request) {
+	rules, err := s.Models.LogEntry.GetRetentionRules()
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: fmt.Sprintf("%d retention rules", len(rules)),
+		Data:    retentionPayload{Rules: rules},
+	})
+}
+
+// SetRetention replaces the retention rules. They are applied by the next purge.
+func (s *Service) SetRetention(w http.ResponseWriter, r *http.Request) {
+	var reqPayload retentionPayload
+	if err := s.readJSON(w, r, &reqPayload); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	if reqPayload.Rules == nil {
+		reqPayload.Rules = []data.RetentionRule{}
+	}
+
+	if err := data.ValidateRetentionRules(reqPayload.Rules); err != nil {
+		_ = s.errorJSON(w, err)
+		return
+	}
+
+	if err := s.Models.LogEntry.SetRetentionRules(reqPayload.Rules); err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Message: "retention rules updated",
+		Data:    reqPayload,
+	})
+}
+
+// RetentionReport returns how many entries each rule would delete now,
+// without deleting them.
+func (s *Service) RetentionReport(w http.ResponseWriter, _ *http.Request) {
+	results, err := s.Models.Purge(true)
+	if err != nil {
+		_ = s.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.writeJSON(w, http.StatusOK, jsonResponse{
+		Message: "dry run, nothing was deleted",
+		Data:    results,
+	})
+}
+
+// purgeLogs deletes the entries past their retention every interval.
+func (s *Service) purgeLogs(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		results, err := s.Models.Purge(false)
+		if err != nil {
+			log.Printf("Purging logs error: %v\n", err)
+			continue
+		}
+
+		for _, result := range results {
+			if result.Deleted > 0 {
+				log.Printf(
+					"Purged %d logs of name %q and severity %q stored before %s\n",
+					result.Deleted, result.Rule.Name, result.Rule.Severity, result.Cutoff.Format(time.RFC3339),
+				)
+			}
+		}
+	}
+}
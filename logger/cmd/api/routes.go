@@ -23,6 +23,15 @@ func (s *Service) router() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Post("/log", s.WriteLog)
+	mux.Get("/logs", s.GetLogs)
+	mux.Get("/logs/stream", s.StreamLogs)
+	mux.Get("/logs/stats", s.GetStats)
+	mux.Get("/logs/export", s.ExportLogs)
+	mux.Get("/logs/{id}", s.GetLog)
+
+	mux.Get("/retention", s.GetRetention)
+	mux.Put("/retention", s.SetRetention)
+	mux.Get("/retention/report", s.RetentionReport)
 
 	return mux
 }
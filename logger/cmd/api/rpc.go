@@ -1,7 +1,8 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"logger/data"
 	"time"
@@ -9,25 +10,51 @@ import (
 
 // RPCServer is the type for our RPC Server. Methods that take this as
 // a receiver are available over RPC, as long as they are exported.
-type RPCServer struct{}
+type RPCServer struct {
+	Models data.Models
+}
 
 // RPCPayload is the type for data we receive from RPC.
+// Fields other than Name and Data are optional, so the callers which
+// send only them keep working. AttributesJSON is the JSON object of the
+// attributes, since gob can't carry map[string]any values. UserID is the
+// user the broker authenticated, kept as the user_id attribute.
 type RPCPayload struct {
-	Name string
-	Data string
+	Name           string
+	Data           string
+	Severity       string
+	Source         string
+	Timestamp      time.Time
+	TraceID        string
+	AttributesJSON string
+	UserID         int
 }
 
-// LogInfo writes our payload to mongo.
+// LogInfo writes our payload to the log store.
 func (rs *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
-	collection := client.Database(data.GetMongoDBName()).
-		Collection(data.GetCollectionName())
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
-		Name:      payload.Name,
-		Data:      payload.Data,
-		CreatedAt: time.Now(),
-	})
-	if err != nil {
-		log.Printf("Error writing to mongo: %v\n", err)
+	var timestamp *time.Time
+	if !payload.Timestamp.IsZero() {
+		timestamp = &payload.Timestamp
+	}
+
+	var attributes map[string]any
+	if payload.AttributesJSON != "" {
+		if err := json.Unmarshal([]byte(payload.AttributesJSON), &attributes); err != nil {
+			return fmt.Errorf("attributes must be a JSON object: %w", err)
+		}
+	}
+	attributes = withUserID(attributes, payload.UserID)
+
+	if _, err := rs.Models.LogEntry.Insert(data.LogEntry{
+		Name:       payload.Name,
+		Data:       payload.Data,
+		Severity:   payload.Severity,
+		Source:     payload.Source,
+		Timestamp:  timestamp,
+		TraceID:    payload.TraceID,
+		Attributes: attributes,
+	}); err != nil {
+		log.Printf("Error writing log: %v\n", err)
 		return err
 	}
 
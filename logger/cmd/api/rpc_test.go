@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"logger/data"
+)
+
+func TestLogInfoKeepsUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload RPCPayload
+		want    any
+	}{
+		{
+			name:    "authenticated",
+			payload: RPCPayload{Name: "auth", Data: "signed in", UserID: 7},
+			want:    float64(7),
+		},
+		{
+			name:    "spoofed attribute",
+			payload: RPCPayload{Name: "auth", Data: "signed in", AttributesJSON: `{"user_id":1}`, UserID: 7},
+			want:    float64(7),
+		},
+		{
+			name:    "anonymous",
+			payload: RPCPayload{Name: "auth", Data: "signed in"},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := data.NewFileStore(filepath.Join(t.TempDir(), "logs.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			rs := &RPCServer{Models: data.New(store)}
+
+			var resp string
+			if err := rs.LogInfo(tt.payload, &resp); err != nil {
+				t.Fatalf("LogInfo error = %v", err)
+			}
+
+			// read back, so the attribute is as stored
+			entry, err := store.GetOne("1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entry.Attributes[userIDAttribute]; got != tt.want {
+				t.Errorf("user_id = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"logger/data"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment, so proxies
+// don't close it.
+const streamHeartbeat = 15 * time.Second
+
+// StreamLogs pushes the entries to the client as they are stored, as
+// Server-Sent Events named log with the entry as JSON data, until the
+// client disconnects. The query string filters them by name and severity.
+// A client which falls behind misses entries, see data.LogFeed.
+func (s *Service) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	severity := strings.ToUpper(r.URL.Query().Get("severity"))
+	switch severity {
+	case "", data.SeverityDebug, data.SeverityInfo, data.SeverityWarning, data.SeverityError:
+	default:
+		_ = s.errorJSON(w, data.ErrInvalidSeverity)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		_ = s.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	entries, unsubscribe := s.Models.Feed.Subscribe(name, severity)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case entry := <-entries:
+			out, err := json.Marshal(entry)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: log\ndata: %s\n\n", entry.ID, out); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
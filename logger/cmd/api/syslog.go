@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"logger/data"
+)
+
+// maxSyslogMessage is the longest syslog message read. Longer ones are
+// dropped, and TCP connections sending them are closed.
+const maxSyslogMessage = 64 * 1024
+
+// syslogName is the name of the entries of messages without APP-NAME.
+const syslogName = "syslog"
+
+// After read and accept errors the listeners wait minSyslogBackoff,
+// doubling on each error in a row up to maxSyslogBackoff, like net/http.
+const (
+	minSyslogBackoff = 5 * time.Millisecond
+	maxSyslogBackoff = time.Second
+)
+
+var errMalformedSyslog = errors.New("not an RFC 5424 syslog message")
+
+// syslogServer receives RFC 5424 syslog messages, both over UDP, a message
+// a datagram, and over TCP, framed by octet counting or by newlines as
+// RFC 6587 describes, until it's closed.
+type syslogServer struct {
+	udp net.PacketConn
+	tcp net.Listener
+	in  *ingester
+
+	// wg tracks the listeners and the connections being served
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newSyslogServer(udp net.PacketConn, tcp net.Listener, in *ingester) *syslogServer {
+	return &syslogServer{
+		udp:   udp,
+		tcp:   tcp,
+		in:    in,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// syslogListen starts receiving syslog messages on port.
+func (s *Service) syslogListen(port string) (*syslogServer, error) {
+	udp, err := net.ListenPacket("udp", fmt.Sprintf(":%s", port))
+	if err != nil {
+		return nil, fmt.Errorf("listening for syslog over UDP: %w", err)
+	}
+	tcp, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
+	if err != nil {
+		_ = udp.Close()
+		return nil, fmt.Errorf("listening for syslog over TCP: %w", err)
+	}
+
+	log.Printf("Syslog server started on port %s", port)
+
+	srv := newSyslogServer(udp, tcp, newIngester(s.Models, "syslog"))
+	srv.serve()
+	return srv, nil
+}
+
+// serve starts reading the datagrams and accepting the connections.
+func (srv *syslogServer) serve() {
+	srv.wg.Add(2)
+	go func() {
+		defer srv.wg.Done()
+		srv.serveUDP()
+	}()
+	go func() {
+		defer srv.wg.Done()
+		srv.serveTCP()
+	}()
+}
+
+// Close stops the listeners, closes the open connections and waits until
+// the messages received are handed to the store.
+func (srv *syslogServer) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	err := srv.udp.Close()
+	if tcpErr := srv.tcp.Close(); err == nil {
+		err = tcpErr
+	}
+	for conn := range srv.conns {
+		_ = conn.Close()
+	}
+	srv.mu.Unlock()
+
+	srv.wg.Wait()
+	if srv.in != nil {
+		srv.in.wait()
+	}
+	return err
+}
+
+// serveUDP reads the messages of the datagrams until the server is closed.
+func (srv *syslogServer) serveUDP() {
+	buf := make([]byte, maxSyslogMessage)
+	var delay time.Duration
+	for {
+		n, addr, err := srv.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			delay = nextSyslogBackoff(delay)
+			log.Printf("Reading syslog over UDP error, retrying in %v: %v\n", delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		entry, err := parseSyslog(buf[:n])
+		if err != nil {
+			log.Printf("Dropping syslog message from %s: %v\n", addr, err)
+			continue
+		}
+		srv.in.ingest(entry)
+	}
+}
+
+// serveTCP serves the connections until the server is closed.
+func (srv *syslogServer) serveTCP() {
+	var delay time.Duration
+	for {
+		conn, err := srv.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			delay = nextSyslogBackoff(delay)
+			log.Printf("Accepting syslog over TCP error, retrying in %v: %v\n", delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if !srv.track(conn) {
+			_ = conn.Close()
+			return
+		}
+		go func() {
+			defer srv.untrack(conn)
+			srv.serveConn(conn)
+		}()
+	}
+}
+
+// track adds the connection to the ones Close closes, unless the server
+// is closed already.
+func (srv *syslogServer) track(conn net.Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.closed {
+		return false
+	}
+	srv.conns[conn] = struct{}{}
+	srv.wg.Add(1)
+	return true
+}
+
+func (srv *syslogServer) untrack(conn net.Conn) {
+	srv.mu.Lock()
+	delete(srv.conns, conn)
+	srv.mu.Unlock()
+	srv.wg.Done()
+}
+
+// nextSyslogBackoff returns the wait after another error in a row.
+func nextSyslogBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minSyslogBackoff
+	}
+	if delay *= 2; delay > maxSyslogBackoff {
+		return maxSyslogBackoff
+	}
+	return delay
+}
+
+// serveConn reads the messages of one TCP connection until it's
+// closed or sends one which can't be framed.
+func (srv *syslogServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReaderSize(conn, maxSyslogMessage)
+	for {
+		msg, err := readSyslogFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Reading syslog from %s error: %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(msg) == 0 {
+			continue
+		}
+
+		entry, err := parseSyslog(msg)
+		if err != nil {
+			log.Printf("Dropping syslog message from %s: %v\n", conn.RemoteAddr(), err)
+			continue
+		}
+		srv.in.ingest(entry)
+	}
+}
+
+// readSyslogFrame reads the next message from the stream. Octet counted
+// frames start with their length, while the others end with a newline.
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] < '0' || first[0] > '9' {
+		line, err := r.ReadSlice('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+
+	prefix, err := r.ReadSlice(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+	if err != nil || n > maxSyslogMessage {
+		return nil, fmt.Errorf("malformed frame length %q", prefix)
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// parseSyslog maps the RFC 5424 message to the entry to store:
+//
+//	<PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+//
+// APP-NAME is the name and HOSTNAME the source. The facility, PROCID, MSGID
+// and the params of the structured data, named SD-ID.PARAM-NAME, are kept
+// as attributes.
+func parseSyslog(msg []byte) (data.LogEntry, error) {
+	p := syslogParser{buf: msg}
+
+	pri, err := p.priority()
+	if err != nil {
+		return data.LogEntry{}, err
+	}
+	if version := p.field(); version != "1" {
+		return data.LogEntry{}, errMalformedSyslog
+	}
+
+	entry := data.LogEntry{
+		Name:       syslogName,
+		Severity:   syslogSeverity(pri % 8),
+		Attributes: map[string]any{"facility": pri / 8},
+	}
+
+	if timestamp := p.field(); timestamp != "-" {
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return data.LogEntry{}, fmt.Errorf("malformed timestamp %q", timestamp)
+		}
+		t = t.UTC()
+		entry.Timestamp = &t
+	}
+	if hostname := p.field(); hostname != "-" {
+		entry.Source = hostname
+	}
+	if appName := p.field(); appName != "-" {
+		entry.Name = appName
+	}
+	if procID := p.field(); procID != "-" {
+		entry.Attributes["procid"] = procID
+	}
+	if msgID := p.field(); msgID != "-" {
+		entry.Attributes["msgid"] = msgID
+	}
+
+	if err := p.structuredData(entry.Attributes); err != nil {
+		return data.LogEntry{}, err
+	}
+
+	if p.pos < len(p.buf) {
+		if p.buf[p.pos] != ' ' {
+			return data.LogEntry{}, errMalformedSyslog
+		}
+		text := p.buf[p.pos+1:]
+		text = bytes.TrimPrefix(text, []byte("\xef\xbb\xbf"))
+		entry.Data = string(bytes.TrimRight(text, "\r\n"))
+	}
+
+	return entry, nil
+}
+
+// syslogSeverity maps the severity of syslog to those of the entries.
+func syslogSeverity(severity int) string {
+	switch {
+	case severity <= 3: // emergency, alert, critical and error
+		return data.SeverityError
+	case severity == 4:
+		return data.SeverityWarning
+	case severity <= 6: // notice and informational
+		return data.SeverityInfo
+	default:
+		return data.SeverityDebug
+	}
+}
+
+// syslogParser reads the parts of a message in order.
+type syslogParser struct {
+	buf []byte
+	pos int
+}
+
+// priority reads <PRI>.
+func (p *syslogParser) priority() (int, error) {
+	end := bytes.IndexByte(p.buf, '>')
+	if len(p.buf) == 0 || p.buf[0] != '<' || end < 2 || end > 4 {
+		return 0, errMalformedSyslog
+	}
+
+	pri, err := strconv.Atoi(string(p.buf[1:end]))
+	if err != nil || pri > 191 {
+		return 0, errMalformedSyslog
+	}
+
+	p.pos = end + 1
+	return pri, nil
+}
+
+// field reads the header field up to the next space, and the space.
+func (p *syslogParser) field() string {
+	start := p.pos
+	for p.pos < len(p.buf) && p.buf[p.pos] != ' ' {
+		p.pos++
+	}
+	value := string(p.buf[start:p.pos])
+	if p.pos < len(p.buf) {
+		p.pos++
+	}
+	return value
+}
+
+// structuredData reads STRUCTURED-DATA into attributes.
+func (p *syslogParser) structuredData(attributes map[string]any) error {
+	if p.pos < len(p.buf) && p.buf[p.pos] == '-' {
+		p.pos++
+		return nil
+	}
+
+	for p.pos < len(p.buf) && p.buf[p.pos] == '[' {
+		p.pos++
+		id := p.name()
+		if id == "" {
+			return errMalformedSyslog
+		}
+
+		for p.pos < len(p.buf) && p.buf[p.pos] == ' ' {
+			p.pos++
+			param := p.name()
+			if param == "" || !p.skip('=') || !p.skip('"') {
+				return errMalformedSyslog
+			}
+			value, ok := p.quoted()
+			if !ok {
+				return errMalformedSyslog
+			}
+			attributes[id+"."+param] = value
+		}
+
+		if !p.skip(']') {
+			return errMalformedSyslog
+		}
+	}
+
+	return nil
+}
+
+// name reads SD-ID or PARAM-NAME.
+func (p *syslogParser) name() string {
+	start := p.pos
+	for p.pos < len(p.buf) {
+		switch p.buf[p.pos] {
+		case ' ', '=', ']', '"':
+			return string(p.buf[start:p.pos])
+		}
+		p.pos++
+	}
+	return string(p.buf[start:p.pos])
+}
+
+// quoted reads PARAM-VALUE up to the closing quote, where \", \\ and \]
+// stand for the escaped character.
+func (p *syslogParser) quoted() (string, bool) {
+	var value []byte
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		p.pos++
+
+		switch c {
+		case '"':
+			return string(value), true
+		case '\\':
+			if p.pos < len(p.buf) {
+				switch next := p.buf[p.pos]; next {
+				case '"', '\\', ']':
+					c = next
+					p.pos++
+				}
+			}
+		}
+		value = append(value, c)
+	}
+	return "", false
+}
+
+// skip reads c if it's next.
+func (p *syslogParser) skip(c byte) bool {
+	if p.pos < len(p.buf) && p.buf[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"logger/data"
+)
+
+func TestParseSyslog(t *testing.T) {
+	timestamp := time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC)
+
+	tests := []struct {
+		name    string
+		msg     string
+		want    data.LogEntry
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			msg:  `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 8710 ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`,
+			want: data.LogEntry{
+				Name:      "evntslog",
+				Data:      "An application event",
+				Severity:  data.SeverityInfo,
+				Source:    "mymachine.example.com",
+				Timestamp: &timestamp,
+				Attributes: map[string]any{
+					"facility":                      20,
+					"procid":                        "8710",
+					"msgid":                         "ID47",
+					"exampleSDID@32473.iut":         "3",
+					"exampleSDID@32473.eventSource": "Application",
+				},
+			},
+		},
+		{
+			name: "escaped param values",
+			msg:  `<11>1 - host app - - [meta@1 path="C:\\logs" quote="\"x\"" bracket="a\]b"] failed`,
+			want: data.LogEntry{
+				Name:     "app",
+				Data:     "failed",
+				Severity: data.SeverityError,
+				Source:   "host",
+				Attributes: map[string]any{
+					"facility":       1,
+					"meta@1.path":    `C:\logs`,
+					"meta@1.quote":   `"x"`,
+					"meta@1.bracket": "a]b",
+				},
+			},
+		},
+		{
+			name: "BOM",
+			msg:  "<12>1 2003-10-11T22:14:15.003Z host app - - - \xef\xbb\xbfdisk is almost full\r\n",
+			want: data.LogEntry{
+				Name:       "app",
+				Data:       "disk is almost full",
+				Severity:   data.SeverityWarning,
+				Source:     "host",
+				Timestamp:  &timestamp,
+				Attributes: map[string]any{"facility": 1},
+			},
+		},
+		{
+			name: "NILVALUE fields",
+			msg:  "<15>1 - - - - - -",
+			want: data.LogEntry{
+				Name:       syslogName,
+				Severity:   data.SeverityDebug,
+				Attributes: map[string]any{"facility": 1},
+			},
+		},
+		{name: "empty", msg: "", wantErr: true},
+		{name: "no PRI", msg: "1 - - - - - -", wantErr: true},
+		{name: "unterminated PRI", msg: "<34 1 - - - - - -", wantErr: true},
+		{name: "PRI too large", msg: "<192>1 - - - - - -", wantErr: true},
+		{name: "non-numeric PRI", msg: "<ab>1 - - - - - -", wantErr: true},
+		{name: "RFC 3164", msg: "<34>Oct 11 22:14:15 mymachine su: 'su root' failed", wantErr: true},
+		{name: "malformed timestamp", msg: "<34>1 yesterday host app - - -", wantErr: true},
+		{name: "unterminated structured data", msg: `<34>1 - host app - - [id@1 a="b"`, wantErr: true},
+		{name: "unquoted param value", msg: `<34>1 - host app - - [id@1 a=b]`, wantErr: true},
+		{name: "no space before MSG", msg: `<34>1 - host app - - [id@1 a="b"]text`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSyslog([]byte(tt.msg))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyslogServerClose(t *testing.T) {
+	store, err := data.NewFileStore(filepath.Join(t.TempDir(), "logs.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newSyslogServer(udp, tcp, newIngester(data.New(store), "syslog"))
+	srv.serve()
+
+	// an idle client keeps its connection open
+	client, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("<34>1 - host app - - - opened\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitForSyslogEntries(t, store, 1)
+
+	closed := make(chan error, 1)
+	go func() { closed <- srv.Close() }()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listeners or connections kept running after Close")
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("reading closed connection error = %v, want %v", err, io.EOF)
+	}
+}
+
+// waitForSyslogEntries waits until the store has n entries.
+func waitForSyslogEntries(t *testing.T, store data.LogStore, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		logs, _, err := store.Find(data.LogFilter{Limit: n + 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) >= n {
+			return
+		}
+	}
+	t.Fatalf("store has fewer than %d entries", n)
+}
+
+func TestNextSyslogBackoff(t *testing.T) {
+	var delay time.Duration
+	var got []time.Duration
+	for i := 0; i < 10; i++ {
+		delay = nextSyslogBackoff(delay)
+		got = append(got, delay)
+	}
+
+	if got[0] != minSyslogBackoff {
+		t.Errorf("first delay = %v, want %v", got[0], minSyslogBackoff)
+	}
+	if got[1] != 2*minSyslogBackoff {
+		t.Errorf("second delay = %v, want %v", got[1], 2*minSyslogBackoff)
+	}
+	if last := got[len(got)-1]; last != maxSyslogBackoff {
+		t.Errorf("delay after %d errors = %v, want %v", len(got), last, maxSyslogBackoff)
+	}
+}
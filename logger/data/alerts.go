@@ -0,0 +1,198 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAlertCooldown is the cooldown of alert rules which don't set one.
+const defaultAlertCooldown = 15 * time.Minute
+
+// alertSweepInterval is how often Alerter forgets the names which went idle.
+const alertSweepInterval = time.Minute
+
+// AlertRule fires when Threshold entries with Name and Severity arrive
+// within Window, or on each such entry if Threshold is 1. Empty Name or
+// Severity matches any. The rule counts the entries of each name apart,
+// and fires for each name once per Cooldown at most; the entries matched
+// meanwhile are counted as suppressed in the next alert. The alert is
+// mailed to To.
+type AlertRule struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name,omitempty"`
+	Severity  string   `json:"severity,omitempty"`
+	Threshold int      `json:"threshold,omitempty"`
+	Window    Duration `json:"window,omitempty"`
+	Cooldown  Duration `json:"cooldown,omitempty"`
+	To        string   `json:"to"`
+}
+
+// Alert is one firing of Rule, by Count entries with the name of Entry,
+// the last of which is Entry. Suppressed entries matched the rule during
+// the cooldown before.
+type Alert struct {
+	Rule       AlertRule
+	Entry      *LogEntry
+	Count      int
+	Suppressed int
+}
+
+// ValidateAlertRules normalizes severities of the rules, sets their
+// defaults and checks them.
+func ValidateAlertRules(rules []AlertRule) error {
+	seen := map[string]bool{}
+	for i := range rules {
+		rule := &rules[i]
+
+		if rule.ID == "" {
+			return errors.New("alert rules must have an id")
+		}
+		if seen[rule.ID] {
+			return fmt.Errorf("more than one alert rule %q", rule.ID)
+		}
+		seen[rule.ID] = true
+
+		rule.Severity = strings.ToUpper(strings.TrimSpace(rule.Severity))
+		switch rule.Severity {
+		case "", SeverityDebug, SeverityInfo, SeverityWarning, SeverityError:
+		default:
+			return fmt.Errorf("alert rule %q: %w", rule.ID, ErrInvalidSeverity)
+		}
+
+		if rule.Threshold < 0 {
+			return fmt.Errorf("alert rule %q: threshold can't be negative", rule.ID)
+		}
+		if rule.Threshold == 0 {
+			rule.Threshold = 1
+		}
+		if rule.Threshold > 1 && rule.Window <= 0 {
+			return fmt.Errorf("alert rule %q: window is required with threshold", rule.ID)
+		}
+
+		if rule.Cooldown < 0 {
+			return fmt.Errorf("alert rule %q: cooldown can't be negative", rule.ID)
+		}
+		if rule.Cooldown == 0 {
+			rule.Cooldown = Duration(defaultAlertCooldown)
+		}
+
+		if rule.To == "" {
+			return fmt.Errorf("alert rule %q: to is required", rule.ID)
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if the rule matches the entry.
+func (ar AlertRule) matches(entry *LogEntry) bool {
+	return (ar.Name == "" || ar.Name == entry.Name) &&
+		(ar.Severity == "" || ar.Severity == entry.Severity)
+}
+
+// Alerter evaluates the alert rules against the entries as they arrive.
+// Entries are timed by CreatedAt. The state of a name is forgotten once it's
+// idle: out of its cooldown with no entries left in the window. Entries it
+// suppressed are then not counted in the next alert.
+type Alerter struct {
+	rules []AlertRule
+
+	mu        sync.Mutex
+	states    map[alertKey]*alertState
+	lastSweep time.Time
+}
+
+// alertKey is what the rule with the index counts apart.
+type alertKey struct {
+	rule int
+	name string
+}
+
+type alertState struct {
+	// matched holds the times of the entries within the window, up to
+	// the threshold of the rule
+	matched    []time.Time
+	mutedUntil time.Time
+	suppressed int
+}
+
+// NewAlerter creates Alerter of the validated rules.
+func NewAlerter(rules []AlertRule) *Alerter {
+	return &Alerter{
+		rules:  rules,
+		states: map[alertKey]*alertState{},
+	}
+}
+
+// Evaluate counts the entry in the rules it matches, and returns the
+// alerts of those which fire.
+func (a *Alerter) Evaluate(entry *LogEntry) []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := entry.CreatedAt
+	if now.Sub(a.lastSweep) >= alertSweepInterval {
+		a.sweep(now)
+		a.lastSweep = now
+	}
+
+	var alerts []Alert
+	for i, rule := range a.rules {
+		if !rule.matches(entry) {
+			continue
+		}
+
+		key := alertKey{rule: i, name: entry.Name}
+		state, ok := a.states[key]
+		if !ok {
+			state = &alertState{}
+			a.states[key] = state
+		}
+
+		if now.Before(state.mutedUntil) {
+			state.suppressed++
+			continue
+		}
+
+		// forget the entries which left the window
+		cutoff := now.Add(-time.Duration(rule.Window))
+		kept := state.matched[:0]
+		for _, t := range state.matched {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		state.matched = append(kept, now)
+
+		if len(state.matched) < rule.Threshold {
+			continue
+		}
+
+		alerts = append(alerts, Alert{
+			Rule:       rule,
+			Entry:      entry,
+			Count:      len(state.matched),
+			Suppressed: state.suppressed,
+		})
+		state.matched = state.matched[:0]
+		state.suppressed = 0
+		state.mutedUntil = now.Add(time.Duration(rule.Cooldown))
+	}
+
+	return alerts
+}
+
+// sweep forgets the states which are idle at now.
+func (a *Alerter) sweep(now time.Time) {
+	for key, state := range a.states {
+		cutoff := now.Add(-time.Duration(a.rules[key.rule].Window))
+		if now.Before(state.mutedUntil) ||
+			(len(state.matched) > 0 && state.matched[len(state.matched)-1].After(cutoff)) {
+			continue
+		}
+		delete(a.states, key)
+	}
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAlerterForgetsIdleNames(t *testing.T) {
+	rules := []AlertRule{
+		{ID: "errors", Severity: SeverityError, To: "ops@example.com"},
+		{ID: "bursts", Threshold: 3, Window: Duration(time.Minute), To: "ops@example.com"},
+	}
+	if err := ValidateAlertRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	alerter := NewAlerter(rules)
+
+	for i := 0; i < 100; i++ {
+		alerter.Evaluate(&LogEntry{Name: fmt.Sprintf("service-%d", i), Severity: SeverityError, CreatedAt: testEpoch})
+	}
+	if len(alerter.states) != 200 {
+		t.Fatalf("states = %d, want 200", len(alerter.states))
+	}
+
+	// past the cooldown of the first rule and the window of the second
+	later := testEpoch.Add(defaultAlertCooldown + time.Minute)
+	alerts := alerter.Evaluate(&LogEntry{Name: "service-0", Severity: SeverityError, CreatedAt: later})
+
+	if len(alerter.states) != 2 {
+		t.Errorf("states = %d, want 2 of the last entry", len(alerter.states))
+	}
+	if len(alerts) != 1 || alerts[0].Rule.ID != "errors" {
+		t.Errorf("alerts = %+v, want one of rule errors", alerts)
+	}
+}
+
+func TestAlerterKeepsActiveNames(t *testing.T) {
+	rules := []AlertRule{{ID: "bursts", Threshold: 3, Window: Duration(10 * time.Minute), To: "ops@example.com"}}
+	if err := ValidateAlertRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	alerter := NewAlerter(rules)
+
+	// the sweeps in between must not forget the entries in the window
+	var alerts []Alert
+	for i := 0; i < 3; i++ {
+		alerts = alerter.Evaluate(&LogEntry{Name: "auth", CreatedAt: testEpoch.Add(time.Duration(i) * 2 * time.Minute)})
+	}
+
+	if len(alerts) != 1 || alerts[0].Count != 3 {
+		t.Errorf("alerts = %+v, want one of 3 entries", alerts)
+	}
+}
@@ -0,0 +1,211 @@
+package data
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// enqueueTimeout is how long Insert waits for room in a full buffer before
+// giving up with ErrBufferFull.
+const enqueueTimeout = 5 * time.Second
+
+var (
+	// ErrBufferFull is returned when the writes back up faster than the
+	// store takes them. Callers should retry later.
+	ErrBufferFull = errors.New("log buffer is full, try again later")
+	// ErrWriterClosed is returned for writes after BatchWriter is closed.
+	ErrWriterClosed = errors.New("log writer is closed")
+)
+
+// BatchWriter is the LogStore which buffers the inserted entries and writes
+// them to the wrapped store with InsertMany, once size entries are buffered
+// or wait has passed since the first of them. Inserts return when the batch
+// of their entries is stored, so callers are answered only for durable
+// entries. The entries of one InsertMany are never split across batches,
+// so they are stored in order as the LogStore contract requires. Reads go
+// straight to the wrapped store.
+type BatchWriter struct {
+	LogStore
+	size     int
+	wait     time.Duration
+	requests chan *writeRequest
+	done     chan struct{}
+
+	// mu guards closed, and is read-locked while requests are sent, so
+	// closing waits for the senders
+	mu     sync.RWMutex
+	closed bool
+}
+
+// writeRequest is the entries of one insert waiting in the buffer for
+// their batch.
+type writeRequest struct {
+	entries []LogEntry
+	result  chan writeResult
+}
+
+// writeResult is the entries of the request stored before err, if any.
+type writeResult struct {
+	stored []*LogEntry
+	err    error
+}
+
+// NewBatchWriter creates BatchWriter in front of the store, which buffers
+// up to buffer inserts and writes them in batches of about size entries.
+// Inserts of more than size entries make batches of their own.
+func NewBatchWriter(store LogStore, size int, wait time.Duration, buffer int) *BatchWriter {
+	w := &BatchWriter{
+		LogStore: store,
+		size:     size,
+		wait:     wait,
+		requests: make(chan *writeRequest, buffer),
+		done:     make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// Insert buffers the entry and waits until it is stored. It blocks while
+// the buffer is full, and fails with ErrBufferFull if it stays full.
+func (w *BatchWriter) Insert(entry LogEntry) (*LogEntry, error) {
+	stored, err := w.InsertMany([]LogEntry{entry})
+	if err != nil {
+		return nil, err
+	}
+
+	return stored[0], nil
+}
+
+// InsertMany buffers the entries and waits until they are stored. On error
+// it returns the entries stored before the failure, like the wrapped store.
+func (w *BatchWriter) InsertMany(entries []LogEntry) ([]*LogEntry, error) {
+	if len(entries) == 0 {
+		return []*LogEntry{}, nil
+	}
+
+	req, err := w.enqueue(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	res := <-req.result
+	return res.stored, res.err
+}
+
+// Close writes the buffered entries and closes the wrapped store.
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.requests)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+
+	return w.LogStore.Close()
+}
+
+// enqueue adds the entries to the buffer.
+func (w *BatchWriter) enqueue(entries []LogEntry) (*writeRequest, error) {
+	req := &writeRequest{
+		entries: entries,
+		result:  make(chan writeResult, 1),
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return nil, ErrWriterClosed
+	}
+
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case w.requests <- req:
+		return req, nil
+	case <-timer.C:
+		return nil, ErrBufferFull
+	}
+}
+
+// run collects the buffered entries into batches and writes them, until
+// the buffer is closed and drained.
+func (w *BatchWriter) run() {
+	defer close(w.done)
+
+	batch := make([]*writeRequest, 0, w.size)
+	size := 0
+	timer := time.NewTimer(w.wait)
+	stopTimer(timer)
+
+	for {
+		select {
+		case req, ok := <-w.requests:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+
+			if len(batch) == 0 {
+				timer.Reset(w.wait)
+			}
+			batch = append(batch, req)
+			size += len(req.entries)
+
+			if size >= w.size {
+				stopTimer(timer)
+				w.flush(batch)
+				batch, size = batch[:0], 0
+			}
+		case <-timer.C:
+			w.flush(batch)
+			batch, size = batch[:0], 0
+		}
+	}
+}
+
+// flush writes the batch and answers each of its requests with its
+// entries the store took. The store takes a prefix of the batch, so each
+// request gets a prefix of its entries.
+func (w *BatchWriter) flush(batch []*writeRequest) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var entries []LogEntry
+	for _, req := range batch {
+		entries = append(entries, req.entries...)
+	}
+
+	stored, err := w.LogStore.InsertMany(entries)
+	offset := 0
+	for _, req := range batch {
+		start, end := offset, offset+len(req.entries)
+		offset = end
+
+		if end <= len(stored) {
+			req.result <- writeResult{stored: stored[start:end]}
+			continue
+		}
+		if start > len(stored) {
+			start = len(stored)
+		}
+		req.result <- writeResult{stored: stored[start:], err: err}
+	}
+}
+
+// stopTimer stops the timer and drains its channel, so it can be reset.
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// Formats of exported entries.
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// ErrInvalidFormat is returned for export formats other than FormatNDJSON
+// and FormatCSV.
+var ErrInvalidFormat = errors.New("format must be ndjson or csv")
+
+// csvHeader names the columns of entries exported as CSV. Attributes are
+// written as a JSON object.
+var csvHeader = []string{
+	"id", "created_at", "timestamp", "name", "severity", "source", "trace_id", "data", "attributes",
+}
+
+// ValidateFormat checks the export format is one of FormatNDJSON and FormatCSV.
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatNDJSON, FormatCSV:
+		return nil
+	default:
+		return ErrInvalidFormat
+	}
+}
+
+// Export writes the entries matching the filter to w in the format, oldest
+// first, and gzipped if compress is true. The entries are read one at a
+// time, so exports of any size take little memory. It returns the number
+// of entries written.
+func (m Models) Export(w io.Writer, format string, compress bool, filter LogFilter) (int64, error) {
+	if err := ValidateFormat(format); err != nil {
+		return 0, err
+	}
+
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	bw := bufio.NewWriter(w)
+
+	var cw *csv.Writer
+	var write func(entry *LogEntry) error
+	switch format {
+	case FormatNDJSON:
+		encoder := json.NewEncoder(bw)
+		write = func(entry *LogEntry) error {
+			return encoder.Encode(entry)
+		}
+	case FormatCSV:
+		cw = csv.NewWriter(bw)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		write = func(entry *LogEntry) error {
+			record, err := csvRecord(entry)
+			if err != nil {
+				return err
+			}
+			return cw.Write(record)
+		}
+	}
+
+	var count int64
+	err := m.LogEntry.Export(filter, func(entry *LogEntry) error {
+		if err := write(entry); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return count, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return count, err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// csvRecord converts the entry to its row of csvHeader.
+func csvRecord(entry *LogEntry) ([]string, error) {
+	timestamp := ""
+	if entry.Timestamp != nil {
+		timestamp = entry.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+
+	attributes := ""
+	if len(entry.Attributes) > 0 {
+		out, err := json.Marshal(entry.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		attributes = string(out)
+	}
+
+	return []string{
+		entry.ID,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		timestamp,
+		entry.Name,
+		entry.Severity,
+		entry.Source,
+		entry.TraceID,
+		entry.Data,
+		attributes,
+	}, nil
+}
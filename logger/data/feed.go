@@ -0,0 +1,84 @@
+package data
+
+import "sync"
+
+// feedBuffer is the number of entries a subscriber may fall behind by.
+// Entries beyond it are dropped for that subscriber, so a slow reader
+// never holds up the writes.
+const feedBuffer = 64
+
+// LogFeed passes the entries stored by this logger to its subscribers
+// as they are inserted.
+type LogFeed struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	observers   []func(entry *LogEntry)
+}
+
+type subscriber struct {
+	name     string
+	severity string
+	entries  chan *LogEntry
+}
+
+func newLogFeed() *LogFeed {
+	return &LogFeed{subscribers: map[*subscriber]struct{}{}}
+}
+
+// Subscribe returns the channel of new entries with the name and severity,
+// either of which matches any entry if empty, and the function which ends
+// the subscription.
+func (f *LogFeed) Subscribe(name, severity string) (<-chan *LogEntry, func()) {
+	s := &subscriber{
+		name:     name,
+		severity: severity,
+		entries:  make(chan *LogEntry, feedBuffer),
+	}
+
+	f.mu.Lock()
+	f.subscribers[s] = struct{}{}
+	f.mu.Unlock()
+
+	var once sync.Once
+	return s.entries, func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subscribers, s)
+			f.mu.Unlock()
+		})
+	}
+}
+
+// Observe makes fn be called with every entry as it's published. Unlike
+// subscribers, observers never miss entries, so fn has to return quickly.
+func (f *LogFeed) Observe(fn func(entry *LogEntry)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.observers = append(f.observers, fn)
+}
+
+// publish passes the entry to the subscribers which want it, and to the
+// observers. The observers are called without the lock held, so they may
+// use the feed themselves.
+func (f *LogFeed) publish(entry *LogEntry) {
+	f.mu.Lock()
+	// Observe only appends, so the observers up to now stay as they are
+	observers := f.observers
+
+	for s := range f.subscribers {
+		if (s.name != "" && s.name != entry.Name) || (s.severity != "" && s.severity != entry.Severity) {
+			continue
+		}
+
+		select {
+		case s.entries <- entry:
+		default:
+		}
+	}
+	f.mu.Unlock()
+
+	for _, fn := range observers {
+		fn(entry)
+	}
+}
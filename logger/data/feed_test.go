@@ -0,0 +1,45 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogFeedObserversMayUseFeed(t *testing.T) {
+	feed := newLogFeed()
+	feed.Observe(func(entry *LogEntry) {
+		// observers run without the lock of the feed
+		_, unsubscribe := feed.Subscribe(entry.Name, "")
+		unsubscribe()
+	})
+
+	entries, unsubscribe := feed.Subscribe("auth", SeverityError)
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		feed.publish(&LogEntry{Name: "auth", Severity: SeverityInfo})
+		feed.publish(&LogEntry{Name: "auth", Severity: SeverityError})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish deadlocked")
+	}
+
+	select {
+	case entry := <-entries:
+		if entry.Severity != SeverityError {
+			t.Errorf("got %s entry, want only ERROR", entry.Severity)
+		}
+	default:
+		t.Fatal("subscriber got no entry")
+	}
+	select {
+	case entry := <-entries:
+		t.Errorf("got another entry %+v", entry)
+	default:
+	}
+}
@@ -0,0 +1,452 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileStore is the LogStore which appends the entries to a local file as
+// JSON lines, for running the logger without a database. Every read scans
+// the whole file, so it suits tests and small setups only. The retention
+// rules are kept in a file next to it.
+type fileStore struct {
+	mu            sync.Mutex
+	path          string
+	retentionPath string
+	file          *os.File
+	lastID        int64
+}
+
+// NewFileStore creates LogStore which appends the entries to the file at path,
+// creating it if it doesn't exist.
+func NewFileStore(path string) (LogStore, error) {
+	s := &fileStore{
+		path:          path,
+		retentionPath: path + ".retention.json",
+	}
+
+	// ids continue after the last stored entry
+	if err := s.scan(func(entry *LogEntry) bool {
+		if id, err := strconv.ParseInt(entry.ID, 10, 64); err == nil && id > s.lastID {
+			s.lastID = id
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+
+	return s, nil
+}
+
+func (s *fileStore) Insert(entry LogEntry) (*LogEntry, error) {
+	stored, err := s.InsertMany([]LogEntry{entry})
+	if err != nil {
+		return nil, err
+	}
+
+	return stored[0], nil
+}
+
+// InsertMany appends the entries with one write, and syncs the file once.
+// A failed write is cut off the file, so none of the entries are stored.
+func (s *fileStore) InsertMany(entries []LogEntry) ([]*LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []byte
+	stored := make([]*LogEntry, len(entries))
+	for i := range entries {
+		entry := entries[i]
+		entry.ID = strconv.FormatInt(s.lastID+int64(i)+1, 10)
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(append(lines, line...), '\n')
+		stored[i] = &entry
+	}
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.file.Write(lines); err != nil {
+		_ = s.file.Truncate(info.Size())
+		return nil, err
+	}
+	if err := s.file.Sync(); err != nil {
+		_ = s.file.Truncate(info.Size())
+		return nil, err
+	}
+
+	s.lastID += int64(len(entries))
+	return stored, nil
+}
+
+func (s *fileStore) Find(filter LogFilter) ([]*LogEntry, string, error) {
+	var afterTime time.Time
+	var afterID int64
+	if filter.Cursor != "" {
+		createdAt, rawID, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if afterID, err = strconv.ParseInt(rawID, 10, 64); err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		afterTime = createdAt
+	}
+
+	match := fileLogFilter(filter)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logs := []*LogEntry{}
+	err := s.scan(func(entry *LogEntry) bool {
+		if !match(entry) {
+			return true
+		}
+		if filter.Cursor != "" && !entryBefore(entry, afterTime, afterID) {
+			return true
+		}
+		logs = append(logs, entry)
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// newest first
+	sort.Slice(logs, func(i, j int) bool {
+		return entryBefore(logs[j], logs[i].CreatedAt, fileID(logs[i].ID))
+	})
+
+	next := ""
+	if len(logs) > filter.Limit {
+		logs = logs[:filter.Limit]
+		next = encodeCursor(logs[len(logs)-1])
+	}
+
+	return logs, next, nil
+}
+
+// Export passes the entries matching the filter to fn in the order they
+// were appended, which is oldest first. Cursor and Limit of the filter are
+// ignored. It reads the entries stored when it starts without holding the
+// lock, so inserts don't wait for it: the file is only appended to, and
+// Purge replaces it, so the size of the open file then bounds them.
+func (s *fileStore) Export(filter LogFilter, fn func(entry *LogEntry) error) error {
+	match := fileLogFilter(filter)
+
+	s.mu.Lock()
+	file, size, err := s.openStored()
+	s.mu.Unlock()
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var fnErr error
+	err = scanEntries(io.LimitReader(file, size), func(entry *LogEntry) bool {
+		if !match(entry) {
+			return true
+		}
+		fnErr = fn(entry)
+		return fnErr == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// openStored opens the file for reading and returns the size of the stored
+// entries in it. The lock must be held.
+func (s *fileStore) openStored() (*os.File, int64, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+func (s *fileStore) Stats(query StatsQuery) ([]LogStat, error) {
+	type group struct {
+		bucket   int64
+		name     string
+		severity string
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := map[group]int64{}
+	err := s.scan(func(entry *LogEntry) bool {
+		if (query.Name != "" && entry.Name != query.Name) ||
+			(query.Severity != "" && entry.Severity != query.Severity) ||
+			(!query.From.IsZero() && entry.CreatedAt.Before(query.From)) ||
+			(!query.To.IsZero() && !entry.CreatedAt.Before(query.To)) {
+			return true
+		}
+
+		var g group
+		if query.ByName {
+			g.name = entry.Name
+		}
+		if query.BySeverity {
+			g.severity = entry.Severity
+		}
+		if query.Bucket > 0 {
+			g.bucket = query.bucketOf(entry.CreatedAt).UnixMilli()
+		}
+		counts[g]++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]LogStat, 0, len(counts))
+	for g, count := range counts {
+		stat := LogStat{Name: g.name, Severity: g.severity, Count: count}
+		if query.Bucket > 0 {
+			bucket := time.UnixMilli(g.bucket).UTC()
+			stat.Bucket = &bucket
+		}
+		stats = append(stats, stat)
+	}
+	sortStats(stats)
+
+	return stats, nil
+}
+
+func (s *fileStore) GetOne(id string) (*LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found *LogEntry
+	err := s.scan(func(entry *LogEntry) bool {
+		if entry.ID == id {
+			found = entry
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+
+	return found, nil
+}
+
+func (s *fileStore) GetRetentionRules() ([]RetentionRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := os.ReadFile(s.retentionPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return []RetentionRule{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []RetentionRule{}
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (s *fileStore) SetRetentionRules(rules []RetentionRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.retentionPath, content)
+}
+
+// Purge counts the entries to delete, and unless dryRun is true, rewrites
+// the file without them. Purging is the only time the file is rewritten.
+func (s *fileStore) Purge(rules []RetentionRule, now time.Time, dryRun bool) ([]RetentionResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]RetentionResult, len(rules))
+	for i, rule := range rules {
+		results[i] = RetentionResult{Rule: rule, Cutoff: now.Add(-time.Duration(rule.MaxAge))}
+	}
+
+	var kept []byte
+	err := s.scan(func(entry *LogEntry) bool {
+		if i := followedRule(entry, rules); i >= 0 && entry.CreatedAt.Before(results[i].Cutoff) {
+			results[i].Deleted++
+			return true
+		}
+		if !dryRun {
+			line, _ := json.Marshal(entry)
+			kept = append(append(kept, line...), '\n')
+		}
+		return true
+	})
+	if err != nil || dryRun {
+		return results, err
+	}
+
+	var deleted int64
+	for _, result := range results {
+		deleted += result.Deleted
+	}
+	if deleted == 0 {
+		return results, nil
+	}
+
+	if err := writeFileAtomic(s.path, kept); err != nil {
+		return nil, err
+	}
+
+	// appends go to the new file from now on
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.file.Close()
+	s.file = file
+
+	return results, nil
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// scan calls fn with each stored entry until it returns false.
+func (s *fileStore) scan(fn func(entry *LogEntry) bool) error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return scanEntries(file, fn)
+}
+
+// scanEntries calls fn with each entry of the JSON lines until it returns
+// false.
+func scanEntries(r io.Reader, fn func(entry *LogEntry) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return err
+		}
+		if !fn(&entry) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// fileLogFilter returns the function which matches the entries of the
+// filter, except for its cursor.
+func fileLogFilter(filter LogFilter) func(entry *LogEntry) bool {
+	words := strings.Fields(strings.ToLower(filter.Text))
+
+	return func(entry *LogEntry) bool {
+		if filter.Name != "" && entry.Name != filter.Name {
+			return false
+		}
+		if !filter.From.IsZero() && entry.CreatedAt.Before(filter.From) {
+			return false
+		}
+		if !filter.To.IsZero() && !entry.CreatedAt.Before(filter.To) {
+			return false
+		}
+		return len(words) == 0 || containsAny(strings.ToLower(entry.Data), words)
+	}
+}
+
+// entryBefore returns true if the entry is older than the one created at
+// createdAt with id, or as old with a lower id.
+func entryBefore(entry *LogEntry, createdAt time.Time, id int64) bool {
+	if !entry.CreatedAt.Equal(createdAt) {
+		return entry.CreatedAt.Before(createdAt)
+	}
+	return fileID(entry.ID) < id
+}
+
+// fileID reads the numeric id the file store gives its entries.
+func fileID(id string) int64 {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return n
+}
+
+// containsAny returns true if s contains any of the words, like the text
+// search of Mongo does.
+func containsAny(s string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFileAtomic replaces the file at path with content, so readers see
+// either the old or the new file.
+func writeFileAtomic(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
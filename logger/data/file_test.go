@@ -0,0 +1,191 @@
+package data
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestFileStore creates the file store in a temporary directory and
+// inserts the entries, the i-th created i minutes after testEpoch.
+func newTestFileStore(t *testing.T, entries ...LogEntry) LogStore {
+	t.Helper()
+
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "logs.jsonl"))
+	if err != nil {
+		t.Fatalf("creating store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for i := range entries {
+		entries[i].CreatedAt = testEpoch.Add(time.Duration(i) * time.Minute)
+	}
+	if len(entries) > 0 {
+		if _, err := store.InsertMany(entries); err != nil {
+			t.Fatalf("inserting entries: %v", err)
+		}
+	}
+
+	return store
+}
+
+func entryIDs(entries []*LogEntry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFileStoreInsert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.jsonl")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.Insert(LogEntry{Name: "auth", Data: "signed in", CreatedAt: testEpoch})
+	if err != nil {
+		t.Fatalf("inserting entry: %v", err)
+	}
+	if first.ID != "1" {
+		t.Errorf("ID = %q, want %q", first.ID, "1")
+	}
+
+	got, err := store.GetOne(first.ID)
+	if err != nil {
+		t.Fatalf("getting entry: %v", err)
+	}
+	if got.Name != "auth" || got.Data != "signed in" || !got.CreatedAt.Equal(testEpoch) {
+		t.Errorf("GetOne = %+v, want the inserted entry", got)
+	}
+
+	if _, err := store.GetOne("2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetOne of unknown id error = %v, want %v", err, ErrNotFound)
+	}
+
+	// ids continue after the stored entries when the file is opened again
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	stored, err := store.InsertMany([]LogEntry{{Name: "auth"}, {Name: "mail"}})
+	if err != nil {
+		t.Fatalf("inserting entries: %v", err)
+	}
+	if ids := entryIDs(stored); !equalIDs(ids, []string{"2", "3"}) {
+		t.Errorf("IDs = %v, want [2 3]", ids)
+	}
+}
+
+func TestFileStoreFind(t *testing.T) {
+	store := newTestFileStore(t,
+		LogEntry{Name: "auth", Data: "user signed in"},
+		LogEntry{Name: "mail", Data: "mail sent"},
+		LogEntry{Name: "auth", Data: "Password reset"},
+		LogEntry{Name: "auth", Data: "user signed out"},
+	)
+
+	tests := []struct {
+		name   string
+		filter LogFilter
+		want   []string
+	}{
+		{name: "all", filter: LogFilter{}, want: []string{"4", "3", "2", "1"}},
+		{name: "name", filter: LogFilter{Name: "auth"}, want: []string{"4", "3", "1"}},
+		{
+			name:   "from",
+			filter: LogFilter{From: testEpoch.Add(2 * time.Minute)},
+			want:   []string{"4", "3"},
+		},
+		{
+			name:   "to",
+			filter: LogFilter{To: testEpoch.Add(2 * time.Minute)},
+			want:   []string{"2", "1"},
+		},
+		{name: "text", filter: LogFilter{Text: "signed"}, want: []string{"4", "1"}},
+		{name: "text ignores case", filter: LogFilter{Text: "password"}, want: []string{"3"}},
+		{name: "name and text", filter: LogFilter{Name: "mail", Text: "signed"}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Limit = 10
+
+			logs, next, err := store.Find(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := entryIDs(logs); !equalIDs(ids, tt.want) {
+				t.Errorf("IDs = %v, want %v", ids, tt.want)
+			}
+			if next != "" {
+				t.Errorf("next = %q, want no next page", next)
+			}
+		})
+	}
+}
+
+func TestFileStoreFindPages(t *testing.T) {
+	entries := make([]LogEntry, 5)
+	for i := range entries {
+		entries[i] = LogEntry{Name: "auth"}
+	}
+	store := newTestFileStore(t, entries...)
+
+	// entries created at the same time are paged by id
+	if _, err := store.Insert(LogEntry{Name: "auth", CreatedAt: testEpoch.Add(4 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var pages [][]string
+	filter := LogFilter{Limit: 2}
+	for {
+		logs, next, err := store.Find(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, entryIDs(logs))
+		if next == "" {
+			break
+		}
+		filter.Cursor = next
+	}
+
+	want := [][]string{{"6", "5"}, {"4", "3"}, {"2", "1"}}
+	if len(pages) != len(want) {
+		t.Fatalf("pages = %v, want %v", pages, want)
+	}
+	for i := range want {
+		if !equalIDs(pages[i], want[i]) {
+			t.Errorf("page %d = %v, want %v", i+1, pages[i], want[i])
+		}
+	}
+
+	for _, cursor := range []string{"not a cursor", encodeCursor(&LogEntry{ID: "abc", CreatedAt: testEpoch})} {
+		if _, _, err := store.Find(LogFilter{Limit: 2, Cursor: cursor}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Find with cursor %q error = %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
@@ -1,163 +1,222 @@
 package data
 
 import (
-	"context"
-	"log"
-	"os"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	client *mongo.Client
+	queryTimeout = 15 * time.Second
+	// exportTimeout bounds Export, which reads many more entries than
+	// the other queries.
+	exportTimeout = time.Hour
+)
+
+// Severities of log entries. They match the routing keys of the logs_topic
+// exchange, e.g. log.WARNING.
+const (
+	SeverityDebug   = "DEBUG"
+	SeverityInfo    = "INFO"
+	SeverityWarning = "WARNING"
+	SeverityError   = "ERROR"
+)
 
-	mongoDbName        = os.Getenv("MONGO_DBNAME")
-	mongoQueryTimeout  = 15
-	logsCollectionName = "logs"
+var (
+	ErrInvalidSeverity  = errors.New("severity must be one of DEBUG, INFO, WARNING and ERROR")
+	ErrInvalidAttribute = errors.New("attributes must be strings, numbers or booleans")
+	// ErrInvalidCursor is returned by Find for cursors it didn't issue.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrNotFound is returned by GetOne for ids of no stored entry.
+	ErrNotFound = errors.New("log not found")
 )
 
+// LogStore is the interface of the storage which holds log entries and
+// their retention rules. HTTP, RPC and gRPC all go through it.
+type LogStore interface {
+	// Insert stores the entry and returns it with ID and CreatedAt set.
+	Insert(entry LogEntry) (*LogEntry, error)
+	// InsertMany stores the entries in order and returns them. If it fails,
+	// it returns the entries stored before the failure with the error, and
+	// the rest of them aren't stored, so callers retry only those.
+	InsertMany(entries []LogEntry) ([]*LogEntry, error)
+	// Find returns one page of the entries matching the filter, newest first,
+	// and the cursor of the next page, which is empty on the last page.
+	Find(filter LogFilter) ([]*LogEntry, string, error)
+	// Export passes the entries matching the filter to fn one at a time,
+	// oldest first, and stops at the first error fn returns. Cursor and
+	// Limit of the filter are ignored.
+	Export(filter LogFilter, fn func(entry *LogEntry) error) error
+	// Stats counts the entries matching the validated query in its groups.
+	Stats(query StatsQuery) ([]LogStat, error)
+	// GetOne returns the entry with the id, or ErrNotFound.
+	GetOne(id string) (*LogEntry, error)
+	// GetRetentionRules returns the retention rules.
+	GetRetentionRules() ([]RetentionRule, error)
+	// SetRetentionRules replaces the retention rules with the validated rules.
+	SetRetentionRules(rules []RetentionRule) error
+	// Purge deletes the entries older than the rules they follow allow at now,
+	// or only counts them if dryRun is true.
+	Purge(rules []RetentionRule, now time.Time, dryRun bool) ([]RetentionResult, error)
+	// Close releases the storage.
+	Close() error
+}
+
+// Models holds the store every path writes to and reads from, and the feed
+// of entries as they are inserted.
 type Models struct {
-	LogEntry LogEntry
+	LogEntry LogStore
+	Feed     *LogFeed
 }
 
-func New(mongoClient *mongo.Client) Models {
-	client = mongoClient
+// New creates Models of the store. Entries are normalized before they are
+// inserted into it, and published to the feed after.
+func New(store LogStore) Models {
+	feed := newLogFeed()
 	return Models{
-		LogEntry: LogEntry{},
+		LogEntry: &publishingStore{LogStore: store, feed: feed},
+		Feed:     feed,
 	}
 }
 
-func GetMongoDBName() string {
-	return mongoDbName
-}
+// Purge deletes the entries past their retention, or only counts them if
+// dryRun is true.
+func (m Models) Purge(dryRun bool) ([]RetentionResult, error) {
+	rules, err := m.LogEntry.GetRetentionRules()
+	if err != nil {
+		return nil, err
+	}
 
-func GetCollectionName() string {
-	return logsCollectionName
+	return m.LogEntry.Purge(rules, time.Now(), dryRun)
 }
 
-type LogEntry struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string    `bson:"name" json:"name"`
-	Data      string    `bson:"data" json:"data"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+// publishingStore normalizes the entries inserted into LogStore and
+// publishes them once they are stored.
+type publishingStore struct {
+	LogStore
+	feed *LogFeed
 }
 
-func (le *LogEntry) Insert(entry LogEntry) error {
-	collection := client.Database(mongoDbName).Collection(logsCollectionName)
-	_, err := collection.InsertOne(context.TODO(), bson.D{
-		{"name", entry.Name},
-		{"data", entry.Data},
-		{"created_at", time.Now()},
-		{"updated_at", time.Now()},
-	})
-	if err != nil {
-		log.Printf("Error inserting into logs: %v\n", err)
-		return err
+func (s *publishingStore) Insert(entry LogEntry) (*LogEntry, error) {
+	if err := prepareEntry(&entry); err != nil {
+		return nil, err
 	}
-	return nil
-}
-
-func (le *LogEntry) GetAll() ([]*LogEntry, error) {
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		time.Duration(mongoQueryTimeout)*time.Second,
-	)
-	defer cancel()
 
-	collection := client.Database(mongoDbName).Collection(logsCollectionName)
-
-	opts := options.Find().SetSort(bson.D{{"created_at", -1}})
-
-	cursor, err := collection.Find(context.TODO(), bson.D{}, opts)
+	stored, err := s.LogStore.Insert(entry)
 	if err != nil {
-		log.Printf("Finding all logs error: %v\n", err)
 		return nil, err
 	}
-	defer cursor.Close(ctx)
-
-	var logs []*LogEntry
 
-	for cursor.Next(ctx) {
-		var item LogEntry
+	s.feed.publish(stored)
+	return stored, nil
+}
 
-		if err := cursor.Decode(&item); err != nil {
-			log.Printf("Error decoding log into slice: %v\n", err)
+func (s *publishingStore) InsertMany(entries []LogEntry) ([]*LogEntry, error) {
+	for i := range entries {
+		if err := prepareEntry(&entries[i]); err != nil {
 			return nil, err
 		}
-
-		logs = append(logs, &item)
 	}
 
-	return logs, nil
+	stored, err := s.LogStore.InsertMany(entries)
+	for _, entry := range stored {
+		s.feed.publish(entry)
+	}
+	return stored, err
 }
 
-func (le *LogEntry) GetOne(id string) (*LogEntry, error) {
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		time.Duration(mongoQueryTimeout)*time.Second,
-	)
-	defer cancel()
-
-	collection := client.Database(mongoDbName).Collection(logsCollectionName)
-
-	docId, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
+// prepareEntry normalizes the entry and sets the fields of the logger.
+func prepareEntry(entry *LogEntry) error {
+	if err := entry.Normalize(); err != nil {
+		return err
 	}
 
-	var logEntry *LogEntry
+	// the stores keep milliseconds, which cursors rely on
+	entry.ID = ""
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	entry.UpdatedAt = entry.CreatedAt
 
-	res := collection.FindOne(ctx, bson.M{"_id": docId})
-	if err := res.Decode(&logEntry); err != nil {
-		log.Printf("Error decoding log: %v\n", err)
-		return nil, err
-	}
-
-	return logEntry, nil
+	return nil
 }
 
-func (le *LogEntry) DropCollection() error {
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		time.Duration(mongoQueryTimeout)*time.Second,
-	)
-	defer cancel()
+// LogEntry is one stored log entry. Name and Data are all the producers of
+// the old shape send; the other fields they may set are optional.
+// Timestamp is when the producer made the entry, CreatedAt when it was stored.
+type LogEntry struct {
+	ID         string         `bson:"_id,omitempty" json:"id,omitempty"`
+	Name       string         `bson:"name" json:"name"`
+	Data       string         `bson:"data" json:"data"`
+	Severity   string         `bson:"severity,omitempty" json:"severity,omitempty"`
+	Source     string         `bson:"source,omitempty" json:"source,omitempty"`
+	Timestamp  *time.Time     `bson:"timestamp,omitempty" json:"timestamp,omitempty"`
+	TraceID    string         `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	Attributes map[string]any `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	CreatedAt  time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time      `bson:"updated_at" json:"updated_at"`
+}
 
-	collection := client.Database(mongoDbName).Collection(logsCollectionName)
+// Normalize checks the fields set by the producer. Severity is upper-cased,
+// or set to INFO if it's missing, and attributes have to be flat.
+func (le *LogEntry) Normalize() error {
+	le.Severity = strings.ToUpper(strings.TrimSpace(le.Severity))
+	switch le.Severity {
+	case "":
+		le.Severity = SeverityInfo
+	case SeverityDebug, SeverityInfo, SeverityWarning, SeverityError:
+	default:
+		return ErrInvalidSeverity
+	}
 
-	if err := collection.Drop(ctx); err != nil {
-		return err
+	for key, value := range le.Attributes {
+		switch value.(type) {
+		case string, bool, float64, float32, int, int32, int64:
+		default:
+			return fmt.Errorf("%w, %s is not", ErrInvalidAttribute, key)
+		}
 	}
+
 	return nil
 }
 
-func (le *LogEntry) Update() (*mongo.UpdateResult, error) {
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		time.Duration(mongoQueryTimeout)*time.Second,
-	)
-	defer cancel()
+// LogFilter selects log entries. Zero fields don't filter. Text is matched
+// against data, and Cursor continues after the last entry of the previous page.
+type LogFilter struct {
+	Name   string
+	From   time.Time
+	To     time.Time
+	Text   string
+	Cursor string
+	Limit  int
+}
 
-	collection := client.Database(mongoDbName).Collection(logsCollectionName)
+// encodeCursor makes the opaque cursor of the entry, its creation time
+// and id.
+func encodeCursor(entry *LogEntry) string {
+	raw := strconv.FormatInt(entry.CreatedAt.UnixMilli(), 10) + ":" + entry.ID
 
-	docID, err := primitive.ObjectIDFromHex(le.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reads the creation time and id of the entry from its cursor.
+// The stores check the id is one of theirs.
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
 	if err != nil {
-		return nil, err
+		return time.Time{}, "", ErrInvalidCursor
 	}
 
-	res, err := collection.UpdateByID(ctx, docID, bson.D{{"$set", bson.D{
-		{"name", le.Name},
-		{"data", le.Data},
-		{"updated_at", time.Now()},
-	}}})
+	millis, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	ms, err := strconv.ParseInt(millis, 10, 64)
 	if err != nil {
-		return nil, err
+		return time.Time{}, "", ErrInvalidCursor
 	}
 
-	return res, nil
+	return time.UnixMilli(ms).UTC(), id, nil
 }
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLogEntryNormalize(t *testing.T) {
+	tests := []struct {
+		name         string
+		entry        LogEntry
+		wantSeverity string
+		wantErr      error
+	}{
+		{name: "missing severity", entry: LogEntry{}, wantSeverity: SeverityInfo},
+		{name: "blank severity", entry: LogEntry{Severity: "  "}, wantSeverity: SeverityInfo},
+		{name: "lower case severity", entry: LogEntry{Severity: " warning "}, wantSeverity: SeverityWarning},
+		{name: "upper case severity", entry: LogEntry{Severity: "ERROR"}, wantSeverity: SeverityError},
+		{name: "unknown severity", entry: LogEntry{Severity: "FATAL"}, wantErr: ErrInvalidSeverity},
+		{
+			name: "flat attributes",
+			entry: LogEntry{Attributes: map[string]any{
+				"user": "ann", "retries": float64(3), "count": 2, "ok": true,
+			}},
+			wantSeverity: SeverityInfo,
+		},
+		{
+			name:    "nested attribute",
+			entry:   LogEntry{Attributes: map[string]any{"request": map[string]any{"path": "/"}}},
+			wantErr: ErrInvalidAttribute,
+		},
+		{
+			name:    "array attribute",
+			entry:   LogEntry{Attributes: map[string]any{"tags": []any{"a", "b"}}},
+			wantErr: ErrInvalidAttribute,
+		},
+		{
+			name:    "null attribute",
+			entry:   LogEntry{Attributes: map[string]any{"user": nil}},
+			wantErr: ErrInvalidAttribute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := tt.entry
+
+			err := entry.Normalize()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && entry.Severity != tt.wantSeverity {
+				t.Errorf("severity = %q, want %q", entry.Severity, tt.wantSeverity)
+			}
+		})
+	}
+}
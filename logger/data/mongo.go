@@ -0,0 +1,430 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	logsCollectionName      = "logs"
+	retentionCollectionName = "retention_rules"
+)
+
+// mongoStore is the LogStore backed by MongoDB.
+type mongoStore struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+// NewMongoStore creates LogStore which keeps the entries in the logs
+// collection of the database dbName, and creates the indexes it relies on.
+func NewMongoStore(client *mongo.Client, dbName string) (LogStore, error) {
+	s := &mongoStore{
+		client: client,
+		db:     client.Database(dbName),
+	}
+
+	if err := s.ensureIndexes(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ensureIndexes creates the indexes Find relies on. Creating an index
+// which already exists does nothing.
+func (s *mongoStore) ensureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	_, err := s.db.Collection(logsCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "data", Value: "text"}}},
+	})
+	if err != nil {
+		log.Printf("Error creating indexes of logs: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *mongoStore) Insert(entry LogEntry) (*LogEntry, error) {
+	stored, err := s.InsertMany([]LogEntry{entry})
+	if err != nil {
+		return nil, err
+	}
+
+	return stored[0], nil
+}
+
+// InsertMany waits for the entries to be written to the journal, so they
+// survive a crash of the server once it returns. The inserts are ordered,
+// so on a write error the entries before the failed one are stored.
+func (s *mongoStore) InsertMany(entries []LogEntry) ([]*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	docs := make([]any, len(entries))
+	for i, entry := range entries {
+		docs[i] = entry
+	}
+
+	collection := s.db.Collection(
+		logsCollectionName,
+		options.Collection().SetWriteConcern(writeconcern.New(writeconcern.J(true))),
+	)
+
+	res, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(true))
+
+	count := len(entries)
+	if err != nil {
+		log.Printf("Error inserting into logs: %v\n", err)
+
+		// only write errors tell which entries were stored, an unconfirmed
+		// write concern leaves it unknown
+		count = 0
+		var writeErr mongo.BulkWriteException
+		if errors.As(err, &writeErr) && writeErr.WriteConcernError == nil && len(writeErr.WriteErrors) > 0 {
+			count = writeErr.WriteErrors[0].Index
+		}
+	}
+
+	stored := make([]*LogEntry, 0, count)
+	for i := 0; i < count; i++ {
+		entry := entries[i]
+		if id, ok := res.InsertedIDs[i].(primitive.ObjectID); ok {
+			entry.ID = id.Hex()
+		}
+		stored = append(stored, &entry)
+	}
+
+	return stored, err
+}
+
+func (s *mongoStore) Find(filter LogFilter) ([]*LogEntry, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	query := mongoLogFilter(filter)
+	if filter.Cursor != "" {
+		createdAt, hexID, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		id, err := primitive.ObjectIDFromHex(hexID)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		// entries older than the last one, or as old with a lower id
+		query = append(query, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "created_at", Value: bson.D{{Key: "$lt", Value: createdAt}}}},
+			bson.D{{Key: "created_at", Value: createdAt}, {Key: "_id", Value: bson.D{{Key: "$lt", Value: id}}}},
+		}})
+	}
+
+	// one more entry than asked tells whether there is a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(filter.Limit) + 1)
+
+	cursor, err := s.db.Collection(logsCollectionName).Find(ctx, query, opts)
+	if err != nil {
+		log.Printf("Finding logs error: %v\n", err)
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	logs := []*LogEntry{}
+	if err := cursor.All(ctx, &logs); err != nil {
+		log.Printf("Error decoding logs into slice: %v\n", err)
+		return nil, "", err
+	}
+
+	next := ""
+	if len(logs) > filter.Limit {
+		logs = logs[:filter.Limit]
+		next = encodeCursor(logs[len(logs)-1])
+	}
+
+	return logs, next, nil
+}
+
+// Export passes the entries matching the filter to fn, oldest first, as
+// they are read from the cursor. Cursor and Limit of the filter are ignored.
+func (s *mongoStore) Export(filter LogFilter, fn func(entry *LogEntry) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := s.db.Collection(logsCollectionName).Find(ctx, mongoLogFilter(filter), opts)
+	if err != nil {
+		log.Printf("Finding logs error: %v\n", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry LogEntry
+		if err := cursor.Decode(&entry); err != nil {
+			log.Printf("Error decoding log: %v\n", err)
+			return err
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (s *mongoStore) Stats(query StatsQuery) ([]LogStat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	match := bson.D{}
+	if query.Name != "" {
+		match = append(match, bson.E{Key: "name", Value: query.Name})
+	}
+	if query.Severity != "" {
+		match = append(match, bson.E{Key: "severity", Value: mongoSeverity(query.Severity)})
+	}
+	if !query.From.IsZero() || !query.To.IsZero() {
+		createdAt := bson.D{}
+		if !query.From.IsZero() {
+			createdAt = append(createdAt, bson.E{Key: "$gte", Value: query.From})
+		}
+		if !query.To.IsZero() {
+			createdAt = append(createdAt, bson.E{Key: "$lt", Value: query.To})
+		}
+		match = append(match, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	var group bson.D
+	if query.ByName {
+		group = append(group, bson.E{Key: "name", Value: "$name"})
+	}
+	if query.BySeverity {
+		group = append(group, bson.E{Key: "severity", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$severity", SeverityInfo}}}})
+	}
+	if query.Bucket > 0 {
+		// the creation time less its remainder of the bucket, as $dateTrunc
+		// needs MongoDB 5
+		group = append(group, bson.E{Key: "bucket", Value: bson.D{{Key: "$subtract", Value: bson.A{
+			"$created_at",
+			bson.D{{Key: "$mod", Value: bson.A{bson.D{{Key: "$toLong", Value: "$created_at"}}, query.Bucket.Milliseconds()}}},
+		}}}})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: group}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+	}
+
+	cursor, err := s.db.Collection(logsCollectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Printf("Aggregating logs error: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		ID struct {
+			Name     string     `bson:"name"`
+			Severity string     `bson:"severity"`
+			Bucket   *time.Time `bson:"bucket"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		log.Printf("Error decoding stats of logs: %v\n", err)
+		return nil, err
+	}
+
+	stats := make([]LogStat, 0, len(groups))
+	for _, g := range groups {
+		stat := LogStat{Name: g.ID.Name, Severity: g.ID.Severity, Count: g.Count}
+		if g.ID.Bucket != nil {
+			bucket := g.ID.Bucket.UTC()
+			stat.Bucket = &bucket
+		}
+		stats = append(stats, stat)
+	}
+	sortStats(stats)
+
+	return stats, nil
+}
+
+func (s *mongoStore) GetOne(id string) (*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var logEntry *LogEntry
+
+	res := s.db.Collection(logsCollectionName).FindOne(ctx, bson.M{"_id": docID})
+	if err := res.Decode(&logEntry); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		log.Printf("Error decoding log: %v\n", err)
+		return nil, err
+	}
+
+	return logEntry, nil
+}
+
+func (s *mongoStore) GetRetentionRules() ([]RetentionRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	cursor, err := s.db.Collection(retentionCollectionName).Find(ctx, bson.D{})
+	if err != nil {
+		log.Printf("Finding retention rules error: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rules := []RetentionRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		log.Printf("Error decoding retention rules: %v\n", err)
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (s *mongoStore) SetRetentionRules(rules []RetentionRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	collection := s.db.Collection(retentionCollectionName)
+
+	if _, err := collection.DeleteMany(ctx, bson.D{}); err != nil {
+		log.Printf("Error deleting retention rules: %v\n", err)
+		return err
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	docs := make([]any, 0, len(rules))
+	for _, rule := range rules {
+		docs = append(docs, rule)
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		log.Printf("Error inserting retention rules: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *mongoStore) Purge(rules []RetentionRule, now time.Time, dryRun bool) ([]RetentionResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	collection := s.db.Collection(logsCollectionName)
+
+	results := make([]RetentionResult, 0, len(rules))
+	for _, rule := range rules {
+		cutoff := now.Add(-time.Duration(rule.MaxAge))
+
+		filter := append(mongoRuleMatch(rule), bson.E{Key: "created_at", Value: bson.D{{Key: "$lt", Value: cutoff}}})
+
+		// leave the entries which follow a more specific rule to it
+		var others bson.A
+		for _, other := range rule.moreSpecific(rules) {
+			others = append(others, mongoRuleMatch(other))
+		}
+		if len(others) > 0 {
+			filter = append(filter, bson.E{Key: "$nor", Value: others})
+		}
+
+		result := RetentionResult{Rule: rule, Cutoff: cutoff}
+
+		var err error
+		if dryRun {
+			result.Deleted, err = collection.CountDocuments(ctx, filter)
+		} else {
+			var res *mongo.DeleteResult
+			if res, err = collection.DeleteMany(ctx, filter); err == nil {
+				result.Deleted = res.DeletedCount
+			}
+		}
+		if err != nil {
+			log.Printf("Error purging logs: %v\n", err)
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (s *mongoStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	return s.client.Disconnect(ctx)
+}
+
+// mongoLogFilter selects the entries matching the filter, except for its
+// cursor.
+func mongoLogFilter(filter LogFilter) bson.D {
+	query := bson.D{}
+	if filter.Name != "" {
+		query = append(query, bson.E{Key: "name", Value: filter.Name})
+	}
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		createdAt := bson.D{}
+		if !filter.From.IsZero() {
+			createdAt = append(createdAt, bson.E{Key: "$gte", Value: filter.From})
+		}
+		if !filter.To.IsZero() {
+			createdAt = append(createdAt, bson.E{Key: "$lt", Value: filter.To})
+		}
+		query = append(query, bson.E{Key: "created_at", Value: createdAt})
+	}
+	if filter.Text != "" {
+		query = append(query, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: filter.Text}}})
+	}
+	return query
+}
+
+// mongoRuleMatch selects the entries the rule matches.
+func mongoRuleMatch(rule RetentionRule) bson.D {
+	filter := bson.D{}
+	if rule.Name != "" {
+		filter = append(filter, bson.E{Key: "name", Value: rule.Name})
+	}
+	if rule.Severity != "" {
+		filter = append(filter, bson.E{Key: "severity", Value: mongoSeverity(rule.Severity)})
+	}
+	return filter
+}
+
+// mongoSeverity matches the severity. Entries stored before severities
+// were added have no severity field, and are taken as INFO.
+func mongoSeverity(severity string) any {
+	if severity == SeverityInfo {
+		return bson.D{{Key: "$in", Value: bson.A{SeverityInfo, nil}}}
+	}
+	return severity
+}
@@ -0,0 +1,486 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postgresSchema creates the tables of postgresStore. The entries are kept
+// as JSONB, with the fields they are selected by copied into columns.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS logs (
+	id bigserial PRIMARY KEY,
+	name text NOT NULL,
+	severity text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL,
+	entry jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS logs_created_at_idx ON logs (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS logs_name_created_at_idx ON logs (name, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS logs_data_idx ON logs USING gin (to_tsvector('simple', entry->>'data'));
+
+CREATE TABLE IF NOT EXISTS retention_rules (
+	name text NOT NULL,
+	severity text NOT NULL,
+	max_age bigint NOT NULL,
+	PRIMARY KEY (name, severity)
+);
+`
+
+// postgresStore is the LogStore backed by Postgres.
+type postgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates LogStore which keeps the entries in Postgres,
+// and creates its tables if they don't exist.
+func NewPostgresStore(db *sql.DB) (LogStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	// without arguments the statements run together
+	if _, err := db.ExecContext(ctx, postgresSchema); err != nil {
+		log.Printf("Error creating tables of logs: %v\n", err)
+		return nil, err
+	}
+
+	return &postgresStore{db: db}, nil
+}
+
+func (s *postgresStore) Insert(entry LogEntry) (*LogEntry, error) {
+	stored, err := s.InsertMany([]LogEntry{entry})
+	if err != nil {
+		return nil, err
+	}
+
+	return stored[0], nil
+}
+
+// InsertMany inserts the entries in one transaction.
+func (s *postgresStore) InsertMany(entries []LogEntry) ([]*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(
+		ctx,
+		`INSERT INTO logs (name, severity, created_at, entry) VALUES ($1, $2, $3, $4) RETURNING id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	stored := make([]*LogEntry, len(entries))
+	for i := range entries {
+		entry := entries[i]
+
+		doc, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		var id int64
+		if err := stmt.QueryRowContext(ctx, entry.Name, entry.Severity, entry.CreatedAt, string(doc)).
+			Scan(&id); err != nil {
+			log.Printf("Error inserting into logs: %v\n", err)
+			return nil, err
+		}
+
+		entry.ID = strconv.FormatInt(id, 10)
+		stored[i] = &entry
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error inserting into logs: %v\n", err)
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (s *postgresStore) Find(filter LogFilter) ([]*LogEntry, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	where := postgresLogFilter(filter)
+	if filter.Cursor != "" {
+		createdAt, rawID, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		id, err := strconv.ParseInt(rawID, 10, 64)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		// entries older than the last one, or as old with a lower id
+		where.add("(created_at, id) < ($%d, $%d)", createdAt, id)
+	}
+
+	// one more entry than asked tells whether there is a next page
+	query := fmt.Sprintf(
+		`SELECT id, created_at, entry FROM logs WHERE %s ORDER BY created_at DESC, id DESC LIMIT %d`,
+		where.String(),
+		filter.Limit+1,
+	)
+
+	rows, err := s.db.QueryContext(ctx, query, where.args...)
+	if err != nil {
+		log.Printf("Finding logs error: %v\n", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	logs := []*LogEntry{}
+	for rows.Next() {
+		entry, err := scanPostgresEntry(rows)
+		if err != nil {
+			log.Printf("Error decoding log: %v\n", err)
+			return nil, "", err
+		}
+		logs = append(logs, entry)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Finding logs error: %v\n", err)
+		return nil, "", err
+	}
+
+	next := ""
+	if len(logs) > filter.Limit {
+		logs = logs[:filter.Limit]
+		next = encodeCursor(logs[len(logs)-1])
+	}
+
+	return logs, next, nil
+}
+
+// Export passes the entries matching the filter to fn, oldest first, as
+// they are read from the rows. Cursor and Limit of the filter are ignored.
+func (s *postgresStore) Export(filter LogFilter, fn func(entry *LogEntry) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	where := postgresLogFilter(filter)
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, created_at, entry FROM logs WHERE `+where.String()+` ORDER BY created_at, id`,
+		where.args...,
+	)
+	if err != nil {
+		log.Printf("Finding logs error: %v\n", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanPostgresEntry(rows)
+		if err != nil {
+			log.Printf("Error decoding log: %v\n", err)
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *postgresStore) Stats(query StatsQuery) ([]LogStat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var where sqlConditions
+	if query.Name != "" {
+		where.add("name = $%d", query.Name)
+	}
+	if query.Severity != "" {
+		where.add("severity = $%d", query.Severity)
+	}
+	if !query.From.IsZero() {
+		where.add("created_at >= $%d", query.From)
+	}
+	if !query.To.IsZero() {
+		where.add("created_at < $%d", query.To)
+	}
+
+	var columns []string
+	if query.ByName {
+		columns = append(columns, "name")
+	}
+	if query.BySeverity {
+		columns = append(columns, "severity")
+	}
+	args := where.args
+	if query.Bucket > 0 {
+		args = append(args, query.Bucket.Milliseconds())
+		columns = append(columns, fmt.Sprintf(
+			"to_timestamp(floor(extract(epoch FROM created_at) * 1000 / $%[1]d) * $%[1]d / 1000)",
+			len(args),
+		))
+	}
+
+	selected := "count(*)"
+	groupBy := ""
+	if len(columns) > 0 {
+		selected = strings.Join(columns, ", ") + ", " + selected
+		groupBy = " GROUP BY " + strings.Join(columns, ", ")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+selected+` FROM logs WHERE `+where.String()+groupBy, args...)
+	if err != nil {
+		log.Printf("Aggregating logs error: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []LogStat{}
+	for rows.Next() {
+		var stat LogStat
+		var bucket time.Time
+
+		var dest []any
+		if query.ByName {
+			dest = append(dest, &stat.Name)
+		}
+		if query.BySeverity {
+			dest = append(dest, &stat.Severity)
+		}
+		if query.Bucket > 0 {
+			dest = append(dest, &bucket)
+		}
+		dest = append(dest, &stat.Count)
+
+		if err := rows.Scan(dest...); err != nil {
+			log.Printf("Error decoding stats of logs: %v\n", err)
+			return nil, err
+		}
+		if query.Bucket > 0 {
+			bucket = bucket.UTC()
+			stat.Bucket = &bucket
+		}
+		stats = append(stats, stat)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Aggregating logs error: %v\n", err)
+		return nil, err
+	}
+	sortStats(stats)
+
+	return stats, nil
+}
+
+func (s *postgresStore) GetOne(id string) (*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	docID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	row := s.db.QueryRowContext(ctx, `SELECT id, created_at, entry FROM logs WHERE id = $1`, docID)
+	entry, err := scanPostgresEntry(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		log.Printf("Error decoding log: %v\n", err)
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (s *postgresStore) GetRetentionRules() ([]RetentionRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT name, severity, max_age FROM retention_rules`)
+	if err != nil {
+		log.Printf("Finding retention rules error: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []RetentionRule{}
+	for rows.Next() {
+		var rule RetentionRule
+		if err := rows.Scan(&rule.Name, &rule.Severity, &rule.MaxAge); err != nil {
+			log.Printf("Error decoding retention rules: %v\n", err)
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (s *postgresStore) SetRetentionRules(rules []RetentionRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM retention_rules`); err != nil {
+		log.Printf("Error deleting retention rules: %v\n", err)
+		return err
+	}
+
+	for _, rule := range rules {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO retention_rules (name, severity, max_age) VALUES ($1, $2, $3)`,
+			rule.Name,
+			rule.Severity,
+			int64(rule.MaxAge),
+		); err != nil {
+			log.Printf("Error inserting retention rules: %v\n", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *postgresStore) Purge(rules []RetentionRule, now time.Time, dryRun bool) ([]RetentionResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	results := make([]RetentionResult, 0, len(rules))
+	for _, rule := range rules {
+		cutoff := now.Add(-time.Duration(rule.MaxAge))
+
+		var where sqlConditions
+		where.addRule(rule, false)
+		where.add("created_at < $%d", cutoff)
+		// leave the entries which follow a more specific rule to it
+		for _, other := range rule.moreSpecific(rules) {
+			where.addRule(other, true)
+		}
+
+		result := RetentionResult{Rule: rule, Cutoff: cutoff}
+
+		var err error
+		if dryRun {
+			err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM logs WHERE `+where.String(), where.args...).
+				Scan(&result.Deleted)
+		} else {
+			var res sql.Result
+			if res, err = s.db.ExecContext(ctx, `DELETE FROM logs WHERE `+where.String(), where.args...); err == nil {
+				result.Deleted, err = res.RowsAffected()
+			}
+		}
+		if err != nil {
+			log.Printf("Error purging logs: %v\n", err)
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
+
+// postgresLogFilter selects the entries matching the filter, except for
+// its cursor.
+func postgresLogFilter(filter LogFilter) sqlConditions {
+	var where sqlConditions
+	if filter.Name != "" {
+		where.add("name = $%d", filter.Name)
+	}
+	if !filter.From.IsZero() {
+		where.add("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where.add("created_at < $%d", filter.To)
+	}
+	if filter.Text != "" {
+		where.add("to_tsvector('simple', entry->>'data') @@ plainto_tsquery('simple', $%d)", filter.Text)
+	}
+	return where
+}
+
+// scanPostgresEntry reads the entry from the row of id, created_at and entry.
+func scanPostgresEntry(row interface{ Scan(...any) error }) (*LogEntry, error) {
+	var id int64
+	var createdAt time.Time
+	var doc []byte
+	if err := row.Scan(&id, &createdAt, &doc); err != nil {
+		return nil, err
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal(doc, &entry); err != nil {
+		return nil, err
+	}
+	entry.ID = strconv.FormatInt(id, 10)
+	entry.CreatedAt = createdAt.UTC()
+
+	return &entry, nil
+}
+
+// sqlConditions builds the WHERE clause of a query with its arguments.
+type sqlConditions struct {
+	conditions []string
+	args       []any
+}
+
+// add appends the condition with $%d placeholders for each of args.
+func (c *sqlConditions) add(condition string, args ...any) {
+	numbers := make([]any, len(args))
+	for i := range args {
+		numbers[i] = len(c.args) + i + 1
+	}
+	c.conditions = append(c.conditions, fmt.Sprintf(condition, numbers...))
+	c.args = append(c.args, args...)
+}
+
+// addRule appends the condition of entries the rule matches, or of those it
+// doesn't with not.
+func (c *sqlConditions) addRule(rule RetentionRule, not bool) {
+	var match sqlConditions
+	match.args = c.args
+	if rule.Name != "" {
+		match.add("name = $%d", rule.Name)
+	}
+	if rule.Severity != "" {
+		match.add("severity = $%d", rule.Severity)
+	}
+
+	condition := "(" + match.String() + ")"
+	if not {
+		condition = "NOT " + condition
+	}
+	c.conditions = append(c.conditions, condition)
+	c.args = match.args
+}
+
+func (c *sqlConditions) String() string {
+	if len(c.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(c.conditions, " AND ")
+}
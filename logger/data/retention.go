@@ -0,0 +1,123 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// minRetention is the shortest age entries may be kept for, so a typo
+// doesn't wipe the logs.
+const minRetention = time.Hour
+
+// Duration is time.Duration written in JSON as a string such as "720h".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New(`durations must be strings such as "720h"`)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("malformed duration: %w", err)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// RetentionRule keeps the entries with Name and Severity for MaxAge. Empty
+// Name or Severity matches any, and an entry follows the most specific rule
+// matching it: name and severity, then name, then severity, then the rule
+// with neither. Entries no rule matches are kept forever.
+type RetentionRule struct {
+	Name     string   `bson:"name" json:"name,omitempty"`
+	Severity string   `bson:"severity" json:"severity,omitempty"`
+	MaxAge   Duration `bson:"max_age" json:"max_age"`
+}
+
+// RetentionResult tells how many entries the rule deleted, or would delete
+// on a dry run: those stored before Cutoff.
+type RetentionResult struct {
+	Rule    RetentionRule `json:"rule"`
+	Cutoff  time.Time     `json:"cutoff"`
+	Deleted int64         `json:"deleted"`
+}
+
+// specificity ranks the rule for choosing the one an entry follows.
+func (rr RetentionRule) specificity() int {
+	rank := 0
+	if rr.Name != "" {
+		rank += 2
+	}
+	if rr.Severity != "" {
+		rank++
+	}
+	return rank
+}
+
+// matches returns true if the rule matches the entry, whether or not it's
+// the one the entry follows.
+func (rr RetentionRule) matches(entry *LogEntry) bool {
+	return (rr.Name == "" || rr.Name == entry.Name) &&
+		(rr.Severity == "" || rr.Severity == entry.Severity)
+}
+
+// moreSpecific returns the rules which take the entries matching them
+// over from rule.
+func (rr RetentionRule) moreSpecific(rules []RetentionRule) []RetentionRule {
+	var others []RetentionRule
+	for _, other := range rules {
+		if other.specificity() > rr.specificity() {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
+// followedRule returns the index of the most specific of the rules matching
+// the entry, or -1 if none does.
+func followedRule(entry *LogEntry, rules []RetentionRule) int {
+	followed := -1
+	for i, rule := range rules {
+		if rule.matches(entry) && (followed < 0 || rule.specificity() > rules[followed].specificity()) {
+			followed = i
+		}
+	}
+	return followed
+}
+
+// ValidateRetentionRules normalizes severities of the rules and checks them.
+func ValidateRetentionRules(rules []RetentionRule) error {
+	seen := map[string]bool{}
+	for i := range rules {
+		rule := &rules[i]
+
+		rule.Severity = strings.ToUpper(strings.TrimSpace(rule.Severity))
+		switch rule.Severity {
+		case "", SeverityDebug, SeverityInfo, SeverityWarning, SeverityError:
+		default:
+			return ErrInvalidSeverity
+		}
+
+		if time.Duration(rule.MaxAge) < minRetention {
+			return fmt.Errorf("max_age must be at least %s", minRetention)
+		}
+
+		key := rule.Name + "\x00" + rule.Severity
+		if seen[key] {
+			return fmt.Errorf("more than one rule for name %q and severity %q", rule.Name, rule.Severity)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// minStatsBucket is the shortest time bucket of stats.
+	minStatsBucket = time.Second
+	// maxStatsBuckets is the most time buckets one stats query may span.
+	maxStatsBuckets = 10_000
+)
+
+// StatsQuery selects the entries to count and how to group them. The zero
+// Name, Severity, From and To don't filter. The counts are grouped by name
+// with ByName, by severity with BySeverity, and into buckets of the creation
+// time with non-zero Bucket.
+type StatsQuery struct {
+	Name       string
+	Severity   string
+	From       time.Time
+	To         time.Time
+	Bucket     time.Duration
+	ByName     bool
+	BySeverity bool
+}
+
+// LogStat is the count of the entries of one group. The fields the entries
+// aren't grouped by are zero.
+type LogStat struct {
+	Bucket   *time.Time `json:"bucket,omitempty"`
+	Name     string     `json:"name,omitempty"`
+	Severity string     `json:"severity,omitempty"`
+	Count    int64      `json:"count"`
+}
+
+// Validate normalizes the severity of the query and checks its buckets.
+func (q *StatsQuery) Validate() error {
+	q.Severity = strings.ToUpper(strings.TrimSpace(q.Severity))
+	switch q.Severity {
+	case "", SeverityDebug, SeverityInfo, SeverityWarning, SeverityError:
+	default:
+		return ErrInvalidSeverity
+	}
+
+	if q.Bucket == 0 {
+		return nil
+	}
+	if q.Bucket < minStatsBucket {
+		return fmt.Errorf("bucket must be at least %s", minStatsBucket)
+	}
+	if q.From.IsZero() {
+		return errors.New("from is required with bucket")
+	}
+
+	to := q.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if to.Sub(q.From)/q.Bucket > maxStatsBuckets {
+		return fmt.Errorf("from and to span more than %d buckets", maxStatsBuckets)
+	}
+
+	return nil
+}
+
+// bucketOf returns the start of the bucket of the creation time.
+func (q *StatsQuery) bucketOf(createdAt time.Time) time.Time {
+	ms := q.Bucket.Milliseconds()
+	return time.UnixMilli(createdAt.UnixMilli() / ms * ms).UTC()
+}
+
+// sortStats orders the stats by bucket, name and severity.
+func sortStats(stats []LogStat) {
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Bucket != nil && b.Bucket != nil && !a.Bucket.Equal(*b.Bucket) {
+			return a.Bucket.Before(*b.Bucket)
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Severity < b.Severity
+	})
+}
@@ -7,10 +7,12 @@ import (
 )
 
 type mailMessage struct {
-	From    string `json:"from"`
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	Message string `json:"message"`
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Subject  string         `json:"subject"`
+	Message  string         `json:"message"`
+	Template string         `json:"template,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
 }
 
 func (s *Service) SendMail(w http.ResponseWriter, r *http.Request) {
@@ -27,10 +29,12 @@ func (s *Service) SendMail(w http.ResponseWriter, r *http.Request) {
 		fromEmail = os.Getenv("MAIL_FROM_ADDRESS")
 	}
 	msg := Message{
-		From:    fromEmail,
-		To:      reqPayload.To,
-		Subject: reqPayload.Subject,
-		Data:    reqPayload.Message,
+		From:     fromEmail,
+		To:       reqPayload.To,
+		Subject:  reqPayload.Subject,
+		Template: reqPayload.Template,
+		Data:     reqPayload.Message,
+		DataMap:  reqPayload.Data,
 	}
 
 	if err := s.Mailer.SendSMTPMessage(msg); err != nil {
@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"html/template"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -13,8 +16,10 @@ import (
 )
 
 var (
-	templateHTMLFile      = "./templates/mail.html.gohtml"
-	templatePlainTextFile = "./templates/mail.plain.gohtml"
+	templatesDir       = "./templates"
+	templateDefault    = "mail"
+	templateNameRX     = regexp.MustCompile("^[a-z0-9-]+$")
+	errInvalidTemplate = errors.New("invalid template name")
 )
 
 type Mail struct {
@@ -33,6 +38,7 @@ type Message struct {
 	FromName    string
 	To          string
 	Subject     string
+	Template    string
 	Attachments []string
 	Data        any
 	DataMap     map[string]any
@@ -61,10 +67,25 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 		msg.FromName = m.FromName
 	}
 
+	if msg.Template == "" {
+		msg.Template = templateDefault
+	}
+
+	if !templateNameRX.MatchString(msg.Template) {
+		return errInvalidTemplate
+	}
+
 	data := map[string]any{
 		"message": msg.Data,
 	}
 
+	// keep extra data for templates which need more than the message
+	for key, value := range msg.DataMap {
+		if key != "message" {
+			data[key] = value
+		}
+	}
+
 	msg.DataMap = data
 
 	htmlMessage, err := m.buildHTMLMessage(msg)
@@ -119,7 +140,7 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 
 // buildHTMLMessage builds HTML-formatted message and get it.
 func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
-	t, err := template.New("email-html").ParseFiles(templateHTMLFile)
+	t, err := template.New("email-html").ParseFiles(templateFile(msg.Template, "html"))
 	if err != nil {
 		return "", err
 	}
@@ -141,7 +162,7 @@ func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
 
 // buildPlainTextMessage builds plain-text based message and get it.
 func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
-	t, err := template.New("email-plain").ParseFiles(templatePlainTextFile)
+	t, err := template.New("email-plain").ParseFiles(templateFile(msg.Template, "plain"))
 	if err != nil {
 		return "", err
 	}
@@ -154,6 +175,11 @@ func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
 	return tpl.String(), nil
 }
 
+// templateFile gets path to the file of the named template in the given format.
+func templateFile(name, format string) string {
+	return fmt.Sprintf("%s/%s.%s.gohtml", templatesDir, name, format)
+}
+
 // inlineCSS converts css styles from HTML <style> tag
 // into inline CSS inside html tags.
 func (m *Mail) inlineCSS(fmtMsg string) (string, error) {